@@ -0,0 +1,194 @@
+package jankdb
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// staleTempAge is how old a leftover temp file must be before Load removes it.
+// Anything younger may still belong to a Save in progress.
+const staleTempAge = time.Hour
+
+// writeOptions controls how atomicWriteFile replaces a file.
+type writeOptions struct {
+	backup  bool // rename the old file to .bak just before replacing it
+	durable bool // fsync the file and its directory; the FileSystem must be a Syncer
+
+	perm  os.FileMode // mode for a new file; an existing file's mode is kept
+	owner *FileOwner  // if set, chown the file
+}
+
+// atomicWriteFile writes data to a uniquely named temp file, optionally backups
+// .bak, then renames to final. Unique names keep concurrent writers from
+// clobbering each other's temp files; the last rename wins. The old file is
+// only moved to .bak once the new one is ready, so a failed write leaves it
+// in place.
+func atomicWriteFile(fs FileSystem, finalPath string, data []byte, opts writeOptions) error {
+	return atomicReplace(fs, finalPath, opts, false, func(tmpPath string, perm os.FileMode) error {
+		if err := fs.WriteFile(tmpPath, data, perm); err != nil {
+			return fmt.Errorf("failed to write temp file: %w", err)
+		}
+		return nil
+	})
+}
+
+// atomicWriteStream is atomicWriteFile for data produced by write, which
+// streams into the temp file instead of being held in memory. With durable,
+// the temp file is synced through the handle that wrote it.
+func atomicWriteStream(fs FileSystem, finalPath string, opts writeOptions, write func(io.Writer) error) error {
+	return atomicReplace(fs, finalPath, opts, true, func(tmpPath string, perm os.FileMode) error {
+		f, err := fs.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if err != nil {
+			return fmt.Errorf("failed to create temp file: %w", err)
+		}
+		bw := bufio.NewWriter(f)
+		if err := write(bw); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if err := bw.Flush(); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("failed to write temp file: %w", err)
+		}
+		if opts.durable {
+			if err := f.Sync(); err != nil {
+				_ = f.Close()
+				return fmt.Errorf("failed to sync temp file: %w", err)
+			}
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to close temp file: %w", err)
+		}
+		return nil
+	})
+}
+
+// atomicReplace does the work of atomicWriteFile; writeTmp creates the temp
+// file, and has already synced it if tmpSynced.
+func atomicReplace(fs FileSystem, finalPath string, opts writeOptions, tmpSynced bool, writeTmp func(tmpPath string, perm os.FileMode) error) error {
+	tmpPath, err := tempPath(finalPath)
+	if err != nil {
+		return err
+	}
+	bakPath := finalPath + ".bak"
+
+	perm := opts.perm
+	if perm == 0 {
+		perm = 0600
+	}
+	existing, statErr := fs.Stat(finalPath)
+	if statErr == nil && existing != nil {
+		// Keep the mode of the file we're replacing
+		perm = existing.Mode().Perm()
+	}
+
+	// Write to temp file
+	if err := writeTmp(tmpPath, perm); err != nil {
+		_ = fs.Remove(tmpPath)
+		return err
+	}
+	if err := applyPermissions(fs, tmpPath, perm, opts.owner); err != nil {
+		_ = fs.Remove(tmpPath)
+		return err
+	}
+
+	syncer, canSync := fs.(Syncer)
+	if opts.durable && !canSync {
+		_ = fs.Remove(tmpPath)
+		return fmt.Errorf("durable writes need a file system that implements Syncer")
+	}
+	if opts.durable && !tmpSynced {
+		if err := syncer.SyncFile(tmpPath); err != nil {
+			_ = fs.Remove(tmpPath)
+			return fmt.Errorf("failed to sync temp file: %w", err)
+		}
+	}
+
+	// Backup old file, as late as possible so readers rarely find no file
+	backedUp := false
+	if opts.backup && statErr == nil {
+		// Another writer may have just moved it; that's fine.
+		if err := fs.Rename(finalPath, bakPath); err == nil {
+			backedUp = true
+		} else if !fs.IsNotExist(err) {
+			_ = fs.Remove(tmpPath)
+			return fmt.Errorf("failed to rename old file to .bak: %w", err)
+		}
+	}
+
+	// Rename tmp -> final
+	if err := fs.Rename(tmpPath, finalPath); err != nil {
+		_ = fs.Remove(tmpPath)
+		if backedUp {
+			_ = fs.Rename(bakPath, finalPath)
+		}
+		return fmt.Errorf("failed to rename %s -> %s: %w", tmpPath, finalPath, err)
+	}
+
+	// Make the rename itself durable
+	if opts.durable {
+		dir := filepath.Dir(finalPath)
+		if err := syncer.SyncDir(dir); err != nil {
+			return fmt.Errorf("failed to sync directory %s: %w", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// tempPath returns a fresh temp file name next to finalPath: final.tmp-<random>.
+func tempPath(finalPath string) (string, error) {
+	id, err := randomID()
+	if err != nil {
+		return "", err
+	}
+	return finalPath + ".tmp-" + id, nil
+}
+
+// randomID returns 16 random hex characters for unique file names.
+func randomID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random file name: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// isTempFor reports whether name is a temp file written for the file named base.
+func isTempFor(name, base string) bool {
+	return name == base+".tmp" || strings.HasPrefix(name, base+".tmp-")
+}
+
+// isTempName reports whether name looks like a temp file from atomicWriteFile or a Tx.
+func isTempName(name string) bool {
+	return strings.HasSuffix(name, ".tmp") || strings.Contains(name, ".tmp-")
+}
+
+// removeStaleTemps deletes temp files for finalPath left behind by crashed
+// writers. It is best-effort: errors are ignored.
+func removeStaleTemps(fs FileSystem, finalPath string) {
+	dir, base := filepath.Split(finalPath)
+	entries, err := fs.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-staleTempAge)
+	for _, entry := range entries {
+		if entry.IsDir() || !isTempFor(entry.Name(), base) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		_ = fs.Remove(filepath.Join(dir, entry.Name()))
+	}
+}
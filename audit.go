@@ -0,0 +1,270 @@
+package jankdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrAuditTampered is returned by VerifyAuditLog and NewAuditLog when the
+// log's hash chain doesn't hold.
+var ErrAuditTampered = errors.New("audit log has been tampered with")
+
+// AuditOp is the kind of change an AuditEvent records.
+type AuditOp string
+
+const (
+	AuditSet   AuditOp = "set"   // Set, SetContext, Update, ApplyPatch, ...
+	AuditSave  AuditOp = "save"  // Save and every other write of the file
+	AuditRekey AuditOp = "rekey" // Rekey
+)
+
+// AuditEvent is one change to a store. OldHash and NewHash identify the
+// content before and after without revealing it: "sha256:<hex>" of the
+// value's JSON, or for encrypted stores "hmac-sha256:<hex>" keyed by the
+// encryption key, so they can't be used to guess secrets.
+//
+// Seq, Prev and Hash are filled in by AuditLog: the 1-based position in the
+// log, the Hash of the event before (empty for the first), and the hex
+// SHA-256 of the event's JSON with Hash empty.
+type AuditEvent struct {
+	Seq     uint64    `json:"seq"`
+	Time    time.Time `json:"time"`
+	Store   string    `json:"store"`
+	Op      AuditOp   `json:"op"`
+	Actor   string    `json:"actor,omitempty"`
+	OldHash string    `json:"old_hash,omitempty"`
+	NewHash string    `json:"new_hash,omitempty"`
+	Prev    string    `json:"prev,omitempty"`
+	Hash    string    `json:"hash"`
+}
+
+// AuditSink receives a store's AuditEvents, see StoreOptions.Audit. Record
+// is called with the store's lock held.
+type AuditSink interface {
+	Record(event AuditEvent) error
+}
+
+type auditKey int
+
+const actorKey auditKey = 0
+
+// WithActor returns a context that names actor as the one making the change
+// in audit records (and in history entries, unless WithAuthor says otherwise).
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// AuditLog is an AuditSink writing hash-chained NDJSON: each line is an
+// AuditEvent whose Hash covers the line and, through Prev, every line before
+// it. Editing, removing or reordering lines breaks the chain, see
+// VerifyAuditLog; keeping Head somewhere else also catches a rewritten
+// chain or a truncated log. Every line is synced to disk before Record
+// returns. One AuditLog can be shared by several stores.
+type AuditLog struct {
+	mu   sync.Mutex
+	fs   FileSystem
+	path string
+	seq  uint64
+	last string
+}
+
+// NewAuditLog opens the audit log at path, creating it on the first Record.
+// An existing log is verified and appended to; a partial last line left by
+// a crash is dropped.
+func NewAuditLog(fs FileSystem, path string) (*AuditLog, error) {
+	l := &AuditLog{fs: fs, path: path}
+
+	data, err := fs.ReadFile(path)
+	if fs.IsNotExist(err) {
+		return l, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	var chain auditChain
+	if err := chain.verify(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	l.seq, l.last = chain.seq, chain.last
+
+	if complete := bytes.LastIndexByte(data, '\n') + 1; complete < len(data) {
+		_, canSync := fs.(Syncer)
+		opts := writeOptions{durable: canSync, perm: 0o600}
+		if err := atomicWriteFile(fs, path, data[:complete], opts); err != nil {
+			return nil, fmt.Errorf("failed to drop partial audit record: %w", err)
+		}
+	}
+	return l, nil
+}
+
+// Record appends event to the log, filling in Seq, Prev and Hash (and Time, if zero).
+func (l *AuditLog) Record(event AuditEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Time = event.Time.UTC()
+	event.Seq, event.Prev, event.Hash = l.seq+1, l.last, ""
+	unsealed, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit event: %w", err)
+	}
+	event.Hash = hashBytes(unsealed)
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit event: %w", err)
+	}
+
+	if err := appendLine(l.fs, l.path, line, writeOptions{durable: true, perm: 0o600}); err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	l.seq, l.last = event.Seq, event.Hash
+	return nil
+}
+
+// Head returns the Hash of the newest event, or "" if the log is empty.
+func (l *AuditLog) Head() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+// VerifyAuditLog checks the hash chain of an audit log written by AuditLog.
+// It returns an error wrapping ErrAuditTampered naming the first bad line,
+// and otherwise the Hash of the last event so it can be compared to a Head
+// kept elsewhere. A partial last line (a crash mid-write) is ignored.
+func VerifyAuditLog(r io.Reader) (string, error) {
+	var chain auditChain
+	if err := chain.verify(r); err != nil {
+		return "", err
+	}
+	return chain.last, nil
+}
+
+// auditChain follows an audit log's hash chain line by line.
+type auditChain struct {
+	seq  uint64
+	last string
+}
+
+func (c *auditChain) verify(r io.Reader) error {
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read audit log: %w", err)
+		}
+		if err := c.check(line[:len(line)-1]); err != nil {
+			return fmt.Errorf("%w: line %d: %v", ErrAuditTampered, n, err)
+		}
+	}
+}
+
+// check verifies the next line, which must be exactly what Record would write.
+func (c *auditChain) check(line []byte) error {
+	var event AuditEvent
+	if err := json.Unmarshal(line, &event); err != nil {
+		return err
+	}
+	if event.Seq != c.seq+1 {
+		return fmt.Errorf("sequence %d follows %d", event.Seq, c.seq)
+	}
+	if event.Prev != c.last {
+		return errors.New("prev doesn't match the previous hash")
+	}
+	hash := event.Hash
+	event.Hash = ""
+	unsealed, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if hashBytes(unsealed) != hash {
+		return errors.New("hash doesn't match the event")
+	}
+	event.Hash = hash
+	if canonical, err := json.Marshal(event); err != nil || !bytes.Equal(canonical, line) {
+		return errors.New("line isn't in canonical form")
+	}
+	c.seq, c.last = event.Seq, hash
+	return nil
+}
+
+// contentHash identifies v for audit records, see AuditEvent.
+func (s *Store[T]) contentHash(v T) (string, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal data for audit: %w", err)
+	}
+	if s.encryptionKey == "" {
+		return "sha256:" + hashBytes(raw), nil
+	}
+	if s.auditKey == nil {
+		// Stretched like the encryption key, so the hashes don't make
+		// guessing a weak passphrase any cheaper
+		key, err := scrypt.Key([]byte(s.encryptionKey), []byte("jankdb audit"), 32768, 8, 1, 32)
+		if err != nil {
+			return "", fmt.Errorf("failed to derive audit key: %w", err)
+		}
+		s.auditKey = key
+	}
+	mac := hmac.New(sha256.New, s.auditKey)
+	mac.Write(raw)
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// recordAudit sends one event to the sink; the caller must hold s.mu.
+func (s *Store[T]) recordAudit(ctx context.Context, op AuditOp, oldHash, newHash string) error {
+	event := AuditEvent{
+		Time:    time.Now(),
+		Store:   s.filePath(),
+		Op:      op,
+		OldHash: oldHash,
+		NewHash: newHash,
+	}
+	event.Actor, _ = ctx.Value(actorKey).(string)
+	return s.audit.Record(event)
+}
+
+// auditSet records that the data just changed in memory; the caller must
+// hold s.mu.
+func (s *Store[T]) auditSet(ctx context.Context) error {
+	if s.audit == nil {
+		return nil
+	}
+	newHash, err := s.contentHash(s.data)
+	if err != nil {
+		return err
+	}
+	oldHash := s.auditData
+	s.auditData = newHash
+	return s.recordAudit(ctx, AuditSet, oldHash, newHash)
+}
+
+// auditSave records that the data was just written; the caller must hold s.mu.
+func (s *Store[T]) auditSave(ctx context.Context) error {
+	if s.audit == nil {
+		return nil
+	}
+	newHash, err := s.contentHash(s.data)
+	if err != nil {
+		return err
+	}
+	oldHash := s.auditSaved
+	s.auditSaved, s.auditData = newHash, newHash
+	return s.recordAudit(ctx, AuditSave, oldHash, newHash)
+}
@@ -0,0 +1,226 @@
+package jankdb_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/guarzo/jankdb"
+)
+
+func readAuditEvents(t *testing.T, path string) []jankdb.AuditEvent {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	var events []jankdb.AuditEvent
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var e jankdb.AuditEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			t.Fatalf("bad audit line %q: %v", line, err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestStore_Audit(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "audit.log")
+	log, err := jankdb.NewAuditLog(jankdb.OSFileSystem{}, logPath)
+	if err != nil {
+		t.Fatalf("NewAuditLog failed: %v", err)
+	}
+	s, _ := jankdb.NewStore[map[string]string](jankdb.OSFileSystem{}, dir, jankdb.StoreOptions{
+		FileName: "secrets.json",
+		Audit:    log,
+	})
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	ctx := jankdb.WithActor(context.Background(), "alice")
+	if err := s.SetContext(ctx, map[string]string{"db": "hunter2"}); err != nil {
+		t.Fatalf("SetContext failed: %v", err)
+	}
+	if err := s.SaveContext(ctx); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	err = s.UpdateContext(jankdb.WithActor(context.Background(), "bob"), func(m map[string]string) (map[string]string, error) {
+		m["api"] = "s3cret"
+		return m, nil
+	})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	events := readAuditEvents(t, logPath)
+	var ops []string
+	for _, e := range events {
+		ops = append(ops, string(e.Op)+":"+e.Actor)
+		if e.Store != filepath.Join(dir, "secrets.json") {
+			t.Errorf("unexpected store %q", e.Store)
+		}
+		if e.Time.IsZero() {
+			t.Error("missing timestamp")
+		}
+	}
+	if got := strings.Join(ops, ","); got != "set:alice,save:alice,set:bob,save:bob" {
+		t.Fatalf("unexpected events %s", got)
+	}
+	// The hashes link up: each save goes from the previous save to the set before it
+	if events[0].NewHash != events[1].NewHash || events[1].OldHash != "" {
+		t.Errorf("first save hashes don't match its set: %+v", events[:2])
+	}
+	if events[2].OldHash != events[1].NewHash || events[3].OldHash != events[1].NewHash || events[3].NewHash != events[2].NewHash {
+		t.Errorf("second save hashes don't match: %+v", events[1:])
+	}
+	if !strings.HasPrefix(events[1].NewHash, "sha256:") {
+		t.Errorf("expected a sha256 content hash, got %q", events[1].NewHash)
+	}
+
+	f, _ := os.Open(logPath)
+	head, err := jankdb.VerifyAuditLog(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("VerifyAuditLog failed: %v", err)
+	}
+	if head != log.Head() || head != events[3].Hash {
+		t.Errorf("expected head %q, got %q", log.Head(), head)
+	}
+
+	// Reopening continues the chain
+	log2, err := jankdb.NewAuditLog(jankdb.OSFileSystem{}, logPath)
+	if err != nil {
+		t.Fatalf("NewAuditLog failed: %v", err)
+	}
+	if err := log2.Record(jankdb.AuditEvent{Store: "x", Op: jankdb.AuditSet}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	events = readAuditEvents(t, logPath)
+	if last := events[len(events)-1]; last.Seq != 5 || last.Prev != head {
+		t.Errorf("chain not continued: %+v", last)
+	}
+}
+
+func TestStore_AuditEncryptedNoPlaintext(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "audit.log")
+	log, _ := jankdb.NewAuditLog(jankdb.OSFileSystem{}, logPath)
+	s, _ := jankdb.NewStore[map[string]string](jankdb.OSFileSystem{}, dir, jankdb.StoreOptions{
+		FileName:      "secrets.json",
+		EncryptionKey: "old passphrase",
+		Audit:         log,
+	})
+
+	s.Set(map[string]string{"db": "hunter2"})
+	if err := s.SaveContext(jankdb.WithActor(context.Background(), "alice")); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := s.RekeyContext(jankdb.WithActor(context.Background(), "ops"), "new passphrase"); err != nil {
+		t.Fatalf("Rekey failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(logPath)
+	if bytes.Contains(data, []byte("hunter2")) {
+		t.Fatal("audit log contains plaintext")
+	}
+	// A plain hash of a guessable secret would give it away
+	plain, _ := json.Marshal(map[string]string{"db": "hunter2"})
+	if bytes.Contains(data, []byte(fmt.Sprintf("%x", sha256.Sum256(plain)))) {
+		t.Fatal("audit log contains an unkeyed hash of the content")
+	}
+
+	events := readAuditEvents(t, logPath)
+	if len(events) != 3 || events[2].Op != jankdb.AuditRekey || events[2].Actor != "ops" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if !strings.HasPrefix(events[1].NewHash, "hmac-sha256:") {
+		t.Errorf("expected a keyed content hash, got %q", events[1].NewHash)
+	}
+	// Same content, new key => new hash
+	if events[2].OldHash != events[1].NewHash || events[2].NewHash == events[2].OldHash {
+		t.Errorf("unexpected rekey hashes %+v", events[2])
+	}
+
+	// The file now opens with the new key only
+	reopened, _ := jankdb.NewStore[map[string]string](jankdb.OSFileSystem{}, dir, jankdb.StoreOptions{
+		FileName:      "secrets.json",
+		EncryptionKey: "new passphrase",
+	})
+	if err := reopened.Load(); err != nil {
+		t.Fatalf("Load with new key failed: %v", err)
+	}
+	if reopened.Get()["db"] != "hunter2" {
+		t.Errorf("unexpected data %v", reopened.Get())
+	}
+	stale, _ := jankdb.NewStore[map[string]string](jankdb.OSFileSystem{}, dir, jankdb.StoreOptions{
+		FileName:      "secrets.json",
+		EncryptionKey: "old passphrase",
+	})
+	if err := stale.Load(); err == nil {
+		t.Error("expected Load with the old key to fail")
+	}
+}
+
+func TestVerifyAuditLog_Tampering(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "audit.log")
+	log, _ := jankdb.NewAuditLog(jankdb.OSFileSystem{}, logPath)
+	for _, actor := range []string{"alice", "bob", "carol"} {
+		if err := log.Record(jankdb.AuditEvent{Store: "s.json", Op: jankdb.AuditSave, Actor: actor}); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+	data, _ := os.ReadFile(logPath)
+	lines := strings.SplitAfter(string(data), "\n")[:3]
+
+	cases := map[string]string{
+		"edited":      strings.Replace(string(data), `"actor":"bob"`, `"actor":"eve"`, 1),
+		"removed":     lines[0] + lines[2],
+		"reordered":   lines[1] + lines[0] + lines[2],
+		"reformatted": strings.Replace(string(data), `"actor":"bob"`, `"actor": "bob"`, 1),
+	}
+	for name, tampered := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := jankdb.VerifyAuditLog(strings.NewReader(tampered)); !errors.Is(err, jankdb.ErrAuditTampered) {
+				t.Errorf("expected ErrAuditTampered, got %v", err)
+			}
+		})
+	}
+
+	// A torn last line is a crash, not tampering; reopening drops it
+	torn := string(data) + `{"seq":4,"ti`
+	if _, err := jankdb.VerifyAuditLog(strings.NewReader(torn)); err != nil {
+		t.Errorf("unexpected error for torn line: %v", err)
+	}
+	_ = os.WriteFile(logPath, []byte(torn), 0o600)
+	log, err := jankdb.NewAuditLog(jankdb.OSFileSystem{}, logPath)
+	if err != nil {
+		t.Fatalf("NewAuditLog failed: %v", err)
+	}
+	if err := log.Record(jankdb.AuditEvent{Store: "s.json", Op: jankdb.AuditSet}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	f, _ := os.Open(logPath)
+	defer f.Close()
+	if _, err := jankdb.VerifyAuditLog(f); err != nil {
+		t.Errorf("log broken after recovering from a torn line: %v", err)
+	}
+
+	if _, err := jankdb.NewAuditLog(jankdb.OSFileSystem{}, filepath.Join(dir, "missing", "audit.log")); err != nil {
+		t.Errorf("expected a missing log to be created lazily, got %v", err)
+	}
+	_ = os.WriteFile(logPath, []byte(cases["edited"]), 0o600)
+	if _, err := jankdb.NewAuditLog(jankdb.OSFileSystem{}, logPath); !errors.Is(err, jankdb.ErrAuditTampered) {
+		t.Errorf("expected NewAuditLog to refuse a tampered log, got %v", err)
+	}
+}
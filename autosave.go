@@ -0,0 +1,88 @@
+package jankdb
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultAutoSaveDelay = time.Second
+
+// autosaver coalesces many schedule calls into one save, run after `delay`
+// of quiet or `maxDelay` after the first pending change, whichever is sooner.
+type autosaver struct {
+	delay    time.Duration
+	maxDelay time.Duration
+	save     func() error
+	onError  func(error)
+
+	notify   chan struct{}
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newAutosaver(delay, maxDelay time.Duration, save func() error, onError func(error)) *autosaver {
+	if delay <= 0 {
+		delay = defaultAutoSaveDelay
+	}
+	a := &autosaver{
+		delay:    delay,
+		maxDelay: maxDelay,
+		save:     save,
+		onError:  onError,
+		notify:   make(chan struct{}, 1),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// schedule records that there is something to save; it never blocks.
+func (a *autosaver) schedule() {
+	select {
+	case a.notify <- struct{}{}:
+	default:
+	}
+}
+
+// stop ends the background goroutine and waits for any in-flight save.
+func (a *autosaver) stop() {
+	a.stopOnce.Do(func() { close(a.quit) })
+	<-a.done
+}
+
+func (a *autosaver) run() {
+	defer close(a.done)
+
+	timer := time.NewTimer(a.delay)
+	timer.Stop()
+	pending := false
+	var first time.Time
+
+	for {
+		select {
+		case <-a.notify:
+			now := time.Now()
+			if !pending {
+				pending = true
+				first = now
+			}
+			wait := a.delay
+			if a.maxDelay > 0 {
+				if remaining := a.maxDelay - now.Sub(first); remaining < wait {
+					wait = remaining
+				}
+			}
+			timer.Reset(wait)
+		case <-timer.C:
+			pending = false
+			if err := a.save(); err != nil && a.onError != nil {
+				a.onError(err)
+			}
+		case <-a.quit:
+			timer.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package jankdb_test
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/guarzo/jankdb"
+)
+
+func TestStore_IsDirty(t *testing.T) {
+	mockFS, _ := newMemFS()
+	s, _ := jankdb.NewStore[int](mockFS, "/base", jankdb.StoreOptions{FileName: "n.json"})
+
+	if s.IsDirty() {
+		t.Error("new store should not be dirty")
+	}
+	s.Set(1)
+	if !s.IsDirty() {
+		t.Error("expected store to be dirty after Set")
+	}
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if s.IsDirty() {
+		t.Error("expected store to be clean after Save")
+	}
+}
+
+func TestStore_AutoSave_Debounce(t *testing.T) {
+	mockFS, _ := newMemFS()
+	var writes atomic.Int32
+	write := mockFS.WriteFileFunc
+	mockFS.WriteFileFunc = func(path string, data []byte, perm os.FileMode) error {
+		writes.Add(1)
+		return write(path, data, perm)
+	}
+
+	s, _ := jankdb.NewStore[int](mockFS, "/base", jankdb.StoreOptions{
+		FileName:      "n.json",
+		AutoSave:      true,
+		AutoSaveDelay: 50 * time.Millisecond,
+	})
+
+	for i := 0; i < 20; i++ {
+		s.Set(i)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	if got := writes.Load(); got != 1 {
+		t.Errorf("expected 20 Sets to coalesce into 1 write, got %d", got)
+	}
+	if s.IsDirty() {
+		t.Error("expected store to be clean after autosave")
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
+
+func TestStore_AutoSave_FlushOnClose(t *testing.T) {
+	mockFS, files := newMemFS()
+	s, _ := jankdb.NewStore[int](mockFS, "/base", jankdb.StoreOptions{
+		FileName:      "n.json",
+		AutoSave:      true,
+		AutoSaveDelay: time.Hour,
+	})
+
+	s.Set(7)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if string(files["/base/n.json"]) != "7" {
+		t.Errorf("expected pending value to be flushed, got %q", files["/base/n.json"])
+	}
+}
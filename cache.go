@@ -1,6 +1,8 @@
 package jankdb
 
 import (
+	"runtime"
+	"sync"
 	"time"
 
 	gocache "github.com/patrickmn/go-cache"
@@ -9,13 +11,30 @@ import (
 // Cache[T] is a thin wrapper around github.com/patrickmn/go-cache,
 // storing entire T objects under a single key (like "all").
 type Cache[T any] struct {
-	c *gocache.Cache
+	*cache
+}
+
+// cache holds the parts the janitor goroutine needs, so that an unreachable
+// Cache[T] can still be finalized (and its janitor stopped) like go-cache does.
+type cache struct {
+	c        *gocache.Cache
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 func NewCache[T any](defaultExpiration, cleanupInterval time.Duration) *Cache[T] {
-	return &Cache[T]{
-		c: gocache.New(defaultExpiration, cleanupInterval),
+	// We run our own janitor rather than go-cache's so Close can stop it.
+	inner := &cache{
+		c:    gocache.New(defaultExpiration, 0),
+		stop: make(chan struct{}),
+	}
+	if cleanupInterval > 0 {
+		go inner.janitor(cleanupInterval)
 	}
+
+	wrapper := &Cache[T]{inner}
+	runtime.SetFinalizer(wrapper, func(c *Cache[T]) { c.stopJanitor() })
+	return wrapper
 }
 
 // Set stores a T under a given key.
@@ -42,3 +61,26 @@ func (cache *Cache[T]) Get(key string) (T, bool) {
 func (cache *Cache[T]) Delete(key string) {
 	cache.c.Delete(key)
 }
+
+// Close stops the background cleanup goroutine. The cache stays usable,
+// but expired items are no longer purged until they're read.
+func (cache *Cache[T]) Close() {
+	cache.stopJanitor()
+}
+
+func (c *cache) stopJanitor() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+func (c *cache) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.c.DeleteExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
@@ -33,3 +33,14 @@ func TestCache_Delete(t *testing.T) {
 		t.Error("expected key to be deleted")
 	}
 }
+
+func TestCache_Close(t *testing.T) {
+	cache := jankdb.NewCache[string](5*time.Minute, time.Millisecond)
+	cache.Close()
+	cache.Close() // safe to call twice
+
+	cache.Set("k", "v")
+	if val, found := cache.Get("k"); !found || val != "v" {
+		t.Error("expected cache to remain usable after Close")
+	}
+}
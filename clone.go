@@ -0,0 +1,113 @@
+package jankdb
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Cloner can be implemented by T to give Store a cheap deep copy when
+// StoreOptions.Isolate is set. Without it, values are cloned through a JSON round-trip.
+type Cloner[T any] interface {
+	Clone() T
+}
+
+// clone returns a deep copy of v, preferring T's own Clone method.
+func clone[T any](v T) (T, error) {
+	if c, ok := any(v).(Cloner[T]); ok {
+		return c.Clone(), nil
+	}
+
+	var out T
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return out, fmt.Errorf("failed to marshal value for clone: %w", err)
+	}
+	if err := json.Unmarshal(bytes, &out); err != nil {
+		return out, fmt.Errorf("failed to unmarshal value for clone: %w", err)
+	}
+	return out, nil
+}
+
+// checkClonable returns an error if clone can't copy every T faithfully: the
+// JSON round-trip drops unexported fields and can't decode chans, funcs or
+// interfaces with methods. Types implementing Cloner, or their own JSON
+// methods, are trusted.
+func checkClonable[T any]() error {
+	t := reflect.TypeFor[T]()
+	if t.Implements(reflect.TypeFor[Cloner[T]]()) {
+		return nil
+	}
+	if err := checkRoundTrip(t, make(map[reflect.Type]bool)); err != nil {
+		return fmt.Errorf("%s can't be copied through JSON (implement Cloner): %w", t, err)
+	}
+	return nil
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// roundTripsItself reports whether t both encodes and decodes itself.
+func roundTripsItself(t reflect.Type) bool {
+	p := reflect.PointerTo(t)
+	return customJSON(p) && (p.Implements(jsonUnmarshalerType) || p.Implements(textUnmarshalerType))
+}
+
+func checkRoundTrip(t reflect.Type, seen map[reflect.Type]bool) error {
+	if seen[t] || roundTripsItself(t) {
+		return nil
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return fmt.Errorf("%s isn't supported by JSON", t)
+	case reflect.Interface:
+		if t.NumMethod() > 0 {
+			return fmt.Errorf("interface %s can't be decoded", t)
+		}
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return checkRoundTrip(t.Elem(), seen)
+	case reflect.Map:
+		switch k := t.Key(); {
+		case k.Kind() == reflect.String, k.Kind() >= reflect.Int && k.Kind() <= reflect.Uintptr:
+		case reflect.PointerTo(k).Implements(textUnmarshalerType):
+		default:
+			return fmt.Errorf("map key %s isn't supported by JSON", k)
+		}
+		return checkRoundTrip(t.Elem(), seen)
+	case reflect.Struct:
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if f.Anonymous && f.Type.Kind() == reflect.Struct {
+				// Its exported fields are promoted
+				if err := checkRoundTrip(f.Type, seen); err != nil {
+					return err
+				}
+				continue
+			}
+			if !f.IsExported() {
+				return fmt.Errorf("unexported field %s.%s is dropped", t, f.Name)
+			}
+			if f.Tag.Get("json") == "-" {
+				return fmt.Errorf("field %s.%s is tagged json:\"-\"", t, f.Name)
+			}
+			if err := checkRoundTrip(f.Type, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isolated returns a private copy of v if isolation is on. NewStore has
+// checked T with checkClonable, so this only fails if T's own JSON methods do.
+func (s *Store[T]) isolated(v T) (T, error) {
+	if !s.isolate {
+		return v, nil
+	}
+	return clone(v)
+}
@@ -0,0 +1,164 @@
+package jankdb_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/guarzo/jankdb"
+)
+
+type inventory struct {
+	Items map[string]int
+}
+
+func (inv inventory) Clone() inventory {
+	items := make(map[string]int, len(inv.Items))
+	for k, v := range inv.Items {
+		items[k] = v
+	}
+	return inventory{Items: items}
+}
+
+func TestStore_Isolate(t *testing.T) {
+	mockFS, _ := newMemFS()
+	s, _ := jankdb.NewStore[map[string]int](mockFS, "/base", jankdb.StoreOptions{
+		FileName: "data.json",
+		Isolate:  true,
+	})
+
+	in := map[string]int{"a": 1}
+	s.Set(in)
+	in["a"] = 2 // must not leak into the store
+
+	out := s.Get()
+	if out["a"] != 1 {
+		t.Errorf("expected store to keep 1, got %d", out["a"])
+	}
+	out["a"] = 3 // must not leak either
+	if s.Get()["a"] != 1 {
+		t.Errorf("expected Get to return a snapshot, store now has %d", s.Get()["a"])
+	}
+}
+
+func TestStore_Isolate_Cloner(t *testing.T) {
+	mockFS, _ := newMemFS()
+	s, _ := jankdb.NewStore[inventory](mockFS, "/base", jankdb.StoreOptions{
+		FileName: "inv.json",
+		Isolate:  true,
+	})
+
+	s.Set(inventory{Items: map[string]int{"sword": 1}})
+	got := s.Get()
+	got.Items["sword"] = 99
+	if s.Get().Items["sword"] != 1 {
+		t.Error("expected Clone to isolate the store's map")
+	}
+}
+
+type withHidden struct {
+	Name   string
+	secret string
+}
+
+type withStringer struct {
+	Name fmt.Stringer
+}
+
+type stamped struct {
+	At   time.Time
+	Tags map[string][]string
+}
+
+// oneWay marshals fine but refuses to be decoded.
+type oneWay struct{ N int }
+
+func (o *oneWay) UnmarshalJSON([]byte) error { return errors.New("no decoding") }
+
+func TestStore_Isolate_RejectsLossyTypes(t *testing.T) {
+	mockFS, _ := newMemFS()
+	isolate := jankdb.StoreOptions{FileName: "data.json", Isolate: true}
+	if _, err := jankdb.NewStore[withHidden](mockFS, "/base", isolate); err == nil {
+		t.Error("expected unexported fields to be refused")
+	}
+	if _, err := jankdb.NewStore[withStringer](mockFS, "/base", isolate); err == nil {
+		t.Error("expected an interface with methods to be refused")
+	}
+	undo := jankdb.StoreOptions{FileName: "data.json", UndoLimit: 5}
+	if _, err := jankdb.NewStore[withHidden](mockFS, "/base", undo); err == nil {
+		t.Error("expected UndoLimit to need a copyable type too")
+	}
+	if _, err := jankdb.NewStore[withHidden](mockFS, "/base", jankdb.StoreOptions{FileName: "data.json"}); err != nil {
+		t.Errorf("expected no check without Isolate or UndoLimit, got %v", err)
+	}
+	if _, err := jankdb.NewStore[stamped](mockFS, "/base", isolate); err != nil {
+		t.Errorf("expected time.Time and nested maps to be accepted, got %v", err)
+	}
+	if _, err := jankdb.NewShardedStore[withHidden](mockFS, "/base", 2, isolate); err == nil {
+		t.Error("expected ShardedStore to refuse unexported fields too")
+	}
+}
+
+func TestStore_Isolate_CopyFailure(t *testing.T) {
+	mockFS, _ := newMemFS()
+	s, err := jankdb.NewStore[oneWay](mockFS, "/base", jankdb.StoreOptions{
+		FileName: "data.json",
+		Isolate:  true,
+	})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	if err := s.SetContext(context.Background(), oneWay{N: 1}); err == nil {
+		t.Error("expected SetContext to report the failed copy")
+	}
+	s.Set(oneWay{N: 2})
+	if s.IsDirty() {
+		t.Error("expected a value that can't be copied not to be stored")
+	}
+	if err := s.Save(); err == nil {
+		t.Error("expected Save to report the failed Set")
+	}
+}
+
+func benchItems(n int) map[string]int {
+	items := make(map[string]int, n)
+	for i := 0; i < n; i++ {
+		items[fmt.Sprintf("item-%d", i)] = i
+	}
+	return items
+}
+
+func benchmarkGet(b *testing.B, isolate bool) {
+	mockFS, _ := newMemFS()
+	s, _ := jankdb.NewStore[map[string]int](mockFS, "/base", jankdb.StoreOptions{
+		FileName: "data.json",
+		Isolate:  isolate,
+	})
+	s.Set(benchItems(1000))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = s.Get()
+	}
+}
+
+func BenchmarkStore_Get_Shared(b *testing.B)   { benchmarkGet(b, false) }
+func BenchmarkStore_Get_Isolated(b *testing.B) { benchmarkGet(b, true) }
+
+func BenchmarkStore_Get_IsolatedCloner(b *testing.B) {
+	mockFS, _ := newMemFS()
+	s, _ := jankdb.NewStore[inventory](mockFS, "/base", jankdb.StoreOptions{
+		FileName: "inv.json",
+		Isolate:  true,
+	})
+	s.Set(inventory{Items: benchItems(1000)})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = s.Get()
+	}
+}
@@ -0,0 +1,150 @@
+package jankdb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compressor compresses stored files. Its output must start with Magic(),
+// which is how Load recognises compressed files, so compressed and plain
+// files can live side by side. NewReader is given the stream including the magic.
+type Compressor interface {
+	Name() string
+	Magic() []byte
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	// GzipCompression uses compress/gzip; its own header is the magic.
+	GzipCompression Compressor = gzipCompressor{}
+
+	// FlateCompression uses compress/flate behind a short jankdb header.
+	FlateCompression Compressor = flateCompressor{}
+)
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = []Compressor{GzipCompression, FlateCompression}
+)
+
+// RegisterCompressor makes c available for detection when loading files, so
+// files it wrote can be read by stores that don't use it. Compressors passed
+// in StoreOptions.Compression are registered automatically.
+func RegisterCompressor(c Compressor) error {
+	if len(c.Magic()) == 0 {
+		return fmt.Errorf("compressor %s has no magic bytes", c.Name())
+	}
+
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	for _, existing := range compressors {
+		if existing.Name() == c.Name() {
+			return nil
+		}
+		if bytes.HasPrefix(c.Magic(), existing.Magic()) || bytes.HasPrefix(existing.Magic(), c.Magic()) {
+			return fmt.Errorf("compressor %s magic clashes with %s", c.Name(), existing.Name())
+		}
+	}
+	compressors = append(compressors, c)
+	return nil
+}
+
+// detectCompressor returns the registered compressor whose magic starts data, if any.
+func detectCompressor(data []byte) Compressor {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	for _, c := range compressors {
+		if bytes.HasPrefix(data, c.Magic()) {
+			return c
+		}
+	}
+	return nil
+}
+
+// decompress undoes compression if data starts with a known magic, and returns
+// data unchanged otherwise.
+func decompress(data []byte) ([]byte, error) {
+	c := detectCompressor(data)
+	if c == nil {
+		return data, nil
+	}
+	r, err := c.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s reader: %w", c.Name(), err)
+	}
+	defer r.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s-decompress data: %w", c.Name(), err)
+	}
+	return out, nil
+}
+
+// newDecompressReader returns a reader of r's contents, decompressed as they
+// are read if they start with a known magic.
+func newDecompressReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(maxMagicLen())
+	c := detectCompressor(head)
+	if c == nil {
+		return io.NopCloser(br), nil
+	}
+	cr, err := c.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s reader: %w", c.Name(), err)
+	}
+	return cr, nil
+}
+
+// maxMagicLen is the longest registered magic.
+func maxMagicLen() int {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	n := 0
+	for _, c := range compressors {
+		n = max(n, len(c.Magic()))
+	}
+	return n
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string  { return "gzip" }
+func (gzipCompressor) Magic() []byte { return []byte{0x1f, 0x8b} }
+func (gzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+func (gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// flateMagic starts with a NUL so it can't be mistaken for JSON or base64.
+var flateMagic = []byte("\x00JDBZF")
+
+type flateCompressor struct{}
+
+func (flateCompressor) Name() string  { return "flate" }
+func (flateCompressor) Magic() []byte { return flateMagic }
+func (flateCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	if _, err := w.Write(flateMagic); err != nil {
+		return nil, err
+	}
+	return flate.NewWriter(w, flate.DefaultCompression)
+}
+func (flateCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	magic := make([]byte, len(flateMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(magic, flateMagic) {
+		return nil, errors.New("missing flate header")
+	}
+	return flate.NewReader(r), nil
+}
@@ -0,0 +1,94 @@
+package jankdb_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/guarzo/jankdb"
+)
+
+func TestStore_Compression(t *testing.T) {
+	for _, c := range []jankdb.Compressor{jankdb.GzipCompression, jankdb.FlateCompression} {
+		t.Run(c.Name(), func(t *testing.T) {
+			mockFS, files := newMemFS()
+			s, _ := jankdb.NewStore[map[string]int](mockFS, "/base", jankdb.StoreOptions{
+				FileName:    "big.json",
+				Compression: c,
+			})
+			s.Set(benchItems(1000))
+			if err := s.Save(); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+			if !bytes.HasPrefix(files["/base/big.json"], c.Magic()) {
+				t.Errorf("expected file to start with %s magic", c.Name())
+			}
+
+			// A store without Compression still reads it
+			plain, _ := jankdb.NewStore[map[string]int](mockFS, "/base", jankdb.StoreOptions{FileName: "big.json"})
+			if err := plain.Load(); err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+			if plain.Get()["item-999"] != 999 {
+				t.Errorf("expected item-999 = 999, got %d", plain.Get()["item-999"])
+			}
+		})
+	}
+}
+
+func TestStore_Compression_Encrypted(t *testing.T) {
+	mockFS, _ := newMemFS()
+	opts := jankdb.StoreOptions{
+		FileName:      "secret.json",
+		EncryptionKey: "pass123",
+		Compression:   jankdb.GzipCompression,
+	}
+	s, _ := jankdb.NewStore[map[string]int](mockFS, "/base", opts)
+	s.Set(map[string]int{"a": 1})
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	s2, _ := jankdb.NewStore[map[string]int](mockFS, "/base", opts)
+	if err := s2.Load(); err != nil || s2.Get()["a"] != 1 {
+		t.Errorf("expected encrypted+compressed round-trip, got %v (%v)", s2.Get(), err)
+	}
+}
+
+// toyCompressor is a toy compressor to exercise registration.
+type toyCompressor struct{}
+
+func (toyCompressor) Name() string  { return "toy" }
+func (toyCompressor) Magic() []byte { return []byte("\x00TOY") }
+func (toyCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	if _, err := w.Write([]byte("\x00TOY")); err != nil {
+		return nil, err
+	}
+	return nopWriteCloser{w}, nil
+}
+func (toyCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	if _, err := io.ReadFull(r, make([]byte, 4)); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(r), nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestRegisterCompressor(t *testing.T) {
+	if err := jankdb.RegisterCompressor(toyCompressor{}); err != nil {
+		t.Fatalf("RegisterCompressor failed: %v", err)
+	}
+	mockFS, files := newMemFS()
+	files["/base/toy.json"] = []byte("\x00TOY[1,2,3]")
+
+	s, _ := jankdb.NewStore[[]int](mockFS, "/base", jankdb.StoreOptions{FileName: "toy.json"})
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(s.Get()) != 3 {
+		t.Errorf("expected [1 2 3], got %v", s.Get())
+	}
+}
@@ -0,0 +1,180 @@
+package jankdb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// lockContext acquires s.mu for writing, or returns ctx.Err() if ctx is done first.
+func (s *Store[T]) lockContext(ctx context.Context) error {
+	return acquireContext(ctx, s.mu.TryLock, s.mu.Lock, s.mu.Unlock)
+}
+
+// rlockContext is lockContext for reading.
+func (s *Store[T]) rlockContext(ctx context.Context) error {
+	return acquireContext(ctx, s.mu.TryRLock, s.mu.RLock, s.mu.RUnlock)
+}
+
+func acquireContext(ctx context.Context, tryLock func() bool, lock, unlock func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if tryLock() {
+		return nil
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		lock()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		return nil
+	case <-ctx.Done():
+		// We still own the pending lock; hand it straight back once it arrives.
+		go func() {
+			<-acquired
+			unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
+// fsWithContext returns a FileSystem bound to ctx. File systems implementing
+// ContextFileSystem get to do this themselves; anything else is wrapped so
+// that each call fails fast once ctx is done.
+func fsWithContext(ctx context.Context, fs FileSystem) FileSystem {
+	if ctx == context.Background() {
+		return fs
+	}
+	if cfs, ok := fs.(ContextFileSystem); ok {
+		return cfs.WithContext(ctx)
+	}
+	return contextFS{ctx: ctx, fs: fs}
+}
+
+// contextFS checks ctx before every call to the wrapped FileSystem.
+type contextFS struct {
+	ctx context.Context
+	fs  FileSystem
+}
+
+func (c contextFS) ReadFile(path string) ([]byte, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.fs.ReadFile(path)
+}
+func (c contextFS) OpenFile(path string, flag int, perm os.FileMode) (*os.File, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.fs.OpenFile(path, flag, perm)
+}
+func (c contextFS) WriteFile(path string, data []byte, perm os.FileMode) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	return c.fs.WriteFile(path, data, perm)
+}
+func (c contextFS) Stat(path string) (os.FileInfo, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.fs.Stat(path)
+}
+func (c contextFS) Open(path string) (io.ReadCloser, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.fs.Open(path)
+}
+func (c contextFS) MkdirAll(path string, perm os.FileMode) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	return c.fs.MkdirAll(path, perm)
+}
+func (c contextFS) Remove(path string) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	return c.fs.Remove(path)
+}
+func (c contextFS) IsNotExist(err error) bool {
+	return c.fs.IsNotExist(err)
+}
+func (c contextFS) ReadDir(dir string) ([]os.DirEntry, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.fs.ReadDir(dir)
+}
+func (c contextFS) Create(path string) (*os.File, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.fs.Create(path)
+}
+func (c contextFS) Rename(src, dst string) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	return c.fs.Rename(src, dst)
+}
+
+// SyncFile and SyncDir pass through to the wrapped FileSystem if it is a
+// Syncer, and fail otherwise so durable writes aren't silently unsynced.
+func (c contextFS) SyncFile(path string) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	if syncer, ok := c.fs.(Syncer); ok {
+		return syncer.SyncFile(path)
+	}
+	return fmt.Errorf("file system can't sync %s", path)
+}
+func (c contextFS) SyncDir(dir string) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	if syncer, ok := c.fs.(Syncer); ok {
+		return syncer.SyncDir(dir)
+	}
+	return fmt.Errorf("file system can't sync %s", dir)
+}
+
+// Chmod and Chown pass through to the wrapped FileSystem if it is a PermissionFileSystem.
+func (c contextFS) Chmod(path string, mode os.FileMode) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	if pfs, ok := c.fs.(PermissionFileSystem); ok {
+		return pfs.Chmod(path, mode)
+	}
+	return nil
+}
+func (c contextFS) Chown(path string, uid, gid int) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	if pfs, ok := c.fs.(PermissionFileSystem); ok {
+		return pfs.Chown(path, uid, gid)
+	}
+	return fmt.Errorf("file system can't change ownership of %s", path)
+}
+
+// Lstat passes through to the wrapped FileSystem if it is a SymlinkFileSystem.
+func (c contextFS) Lstat(path string) (os.FileInfo, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if lfs, ok := c.fs.(SymlinkFileSystem); ok {
+		return lfs.Lstat(path)
+	}
+	return nil, fmt.Errorf("file system can't lstat %s", path)
+}
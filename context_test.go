@@ -0,0 +1,103 @@
+package jankdb_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/guarzo/jankdb"
+)
+
+func TestStore_SaveContext_Canceled(t *testing.T) {
+	mockFS, files := newMemFS()
+	s, _ := jankdb.NewStore[int](mockFS, "/base", jankdb.StoreOptions{FileName: "n.json"})
+	s.Set(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.SaveContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if _, ok := files["/base/n.json"]; ok {
+		t.Error("expected nothing to be written")
+	}
+}
+
+func TestStore_LoadContext_LockTimeout(t *testing.T) {
+	mockFS, _ := newMemFS()
+	s, _ := jankdb.NewStore[int](mockFS, "/base", jankdb.StoreOptions{FileName: "n.json"})
+
+	// Hold the store's lock with a slow Update
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		_ = s.Update(func(n int) (int, error) {
+			close(started)
+			<-release
+			return n + 1, nil
+		})
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.LoadContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+
+	close(release)
+	if err := s.Load(); err != nil {
+		t.Errorf("expected lock to be usable after timeout, got %v", err)
+	}
+	if s.Get() != 1 {
+		t.Errorf("expected Update result 1, got %d", s.Get())
+	}
+}
+
+func TestStore_Update(t *testing.T) {
+	mockFS, files := newMemFS()
+	s, _ := jankdb.NewStore[int](mockFS, "/base", jankdb.StoreOptions{FileName: "n.json"})
+
+	if err := s.Update(func(n int) (int, error) { return n + 5, nil }); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if string(files["/base/n.json"]) != "5" {
+		t.Errorf("expected 5 on disk, got %q", files["/base/n.json"])
+	}
+
+	boom := errors.New("boom")
+	if err := s.Update(func(n int) (int, error) { return 0, boom }); !errors.Is(err, boom) {
+		t.Errorf("expected fn error, got %v", err)
+	}
+	if s.Get() != 5 {
+		t.Errorf("expected failed Update to leave 5, got %d", s.Get())
+	}
+}
+
+type slowFS struct {
+	jankdb.FileSystem
+	gotCtx context.Context
+}
+
+func (f *slowFS) WithContext(ctx context.Context) jankdb.FileSystem {
+	f.gotCtx = ctx
+	return f.FileSystem
+}
+
+func TestStore_ContextFileSystem(t *testing.T) {
+	mockFS, _ := newMemFS()
+	mockFS.StatFunc = func(path string) (os.FileInfo, error) { return nil, os.ErrNotExist }
+	fs := &slowFS{FileSystem: mockFS}
+
+	s, _ := jankdb.NewStore[int](fs, "/base", jankdb.StoreOptions{FileName: "n.json"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.LoadContext(ctx); err != nil {
+		t.Fatalf("LoadContext failed: %v", err)
+	}
+	if fs.gotCtx != ctx {
+		t.Error("expected ctx to be passed to ContextFileSystem.WithContext")
+	}
+}
@@ -0,0 +1,170 @@
+package jankdb
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// storeExt is the file extension DB gives its stores.
+const storeExt = ".json"
+
+// DB manages a set of named stores that share a FileSystem, base path and
+// default options. Store "loot" lives at basePath/SubDir/loot.json.
+type DB struct {
+	mu sync.Mutex
+
+	fs       FileSystem
+	basePath string
+	defaults StoreOptions
+
+	stores map[string]dbStore
+	closed bool
+}
+
+// dbStore is the untyped view of a *Store[T] that DB needs.
+type dbStore interface {
+	Load() error
+	IsDirty() bool
+	Close() error
+	flush() error
+}
+
+// NewDB creates a DB. defaults applies to every store it opens; its FileName is ignored.
+func NewDB(fs FileSystem, basePath string, defaults StoreOptions) (*DB, error) {
+	if defaults.SubDir != "" {
+		if err := validateRelPath("SubDir", defaults.SubDir); err != nil {
+			return nil, err
+		}
+	}
+	defaults.FileName = ""
+	return &DB{
+		fs:       fs,
+		basePath: basePath,
+		defaults: defaults,
+		stores:   make(map[string]dbStore),
+	}, nil
+}
+
+// Open returns the store called name, creating and loading it on first use.
+// Opening the same name again returns the same *Store[T]; opening it with a
+// different T is an error.
+func Open[T any](db *DB, name string) (*Store[T], error) {
+	if err := validateStoreName(name); err != nil {
+		return nil, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	if existing, ok := db.stores[name]; ok {
+		s, ok := existing.(*Store[T])
+		if !ok {
+			return nil, fmt.Errorf("store %q is already open with type %T", name, existing)
+		}
+		return s, nil
+	}
+
+	opts := db.defaults
+	opts.FileName = name + storeExt
+	s, err := NewStore[T](db.fs, db.basePath, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create store %q: %w", name, err)
+	}
+	if err := s.Load(); err != nil {
+		_ = s.Close()
+		return nil, fmt.Errorf("failed to load store %q: %w", name, err)
+	}
+
+	db.stores[name] = s
+	return s, nil
+}
+
+// List returns the names of all stores on disk, opened or not, sorted. The
+// files of a ShardedStore in the same directory aren't listed.
+func (db *DB) List() ([]string, error) {
+	dir := db.basePath
+	if db.defaults.SubDir != "" {
+		dir = filepath.Join(db.basePath, db.defaults.SubDir)
+	}
+
+	entries, err := db.fs.ReadDir(dir)
+	if err != nil && !db.fs.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read directory %s: %w", dir, err)
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), storeExt) ||
+			isReservedName(entry.Name()) || isShardSetFile(entry.Name()) {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), storeExt)
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	// Include opened stores that haven't been saved yet
+	db.mu.Lock()
+	for name := range db.stores {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	db.mu.Unlock()
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// Flush saves every open store that has unsaved changes.
+func (db *DB) Flush() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var errs []error
+	for name, s := range db.stores {
+		if err := s.flush(); err != nil && !errors.Is(err, ErrClosed) {
+			errs = append(errs, fmt.Errorf("store %q: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close flushes and closes every open store. Afterwards Open returns
+// ErrClosed. If a store fails to flush, the DB stays open so Close can be
+// retried; the stores that did close stay closed.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+
+	var errs []error
+	for name, s := range db.stores {
+		if err := s.Close(); err != nil && !errors.Is(err, ErrClosed) {
+			errs = append(errs, fmt.Errorf("store %q: %w", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	db.closed = true
+	return nil
+}
+
+// validateStoreName makes sure a store name maps to a single file.
+func validateStoreName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return fmt.Errorf("%w: store name %q", ErrInvalidPath, name)
+	}
+	return nil
+}
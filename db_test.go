@@ -0,0 +1,131 @@
+package jankdb_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/guarzo/jankdb"
+)
+
+func TestDB_OpenListClose(t *testing.T) {
+	dir := t.TempDir()
+	db, err := jankdb.NewDB(jankdb.OSFileSystem{}, dir, jankdb.StoreOptions{
+		SubDir:        "app",
+		EncryptionKey: "pass123",
+	})
+	if err != nil {
+		t.Fatalf("NewDB failed: %v", err)
+	}
+
+	loot, err := jankdb.Open[map[string]int](db, "loot")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	loot.Set(map[string]int{"gold": 10})
+	if err := loot.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	again, _ := jankdb.Open[map[string]int](db, "loot")
+	if again != loot {
+		t.Error("expected Open to return the same store for the same name")
+	}
+	if _, err := jankdb.Open[string](db, "loot"); err == nil {
+		t.Error("expected an error opening 'loot' with a different type")
+	}
+
+	names, _ := jankdb.Open[[]string](db, "names")
+	names.Set([]string{"a"}) // unsaved until Close
+
+	list, err := db.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 2 || list[0] != "loot" || list[1] != "names" {
+		t.Errorf("expected [loot names], got %v", list)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := jankdb.Open[int](db, "other"); !errors.Is(err, jankdb.ErrClosed) {
+		t.Errorf("expected ErrClosed after Close, got %v", err)
+	}
+
+	// Reopen: data was encrypted with the default key and flushed on Close
+	db2, _ := jankdb.NewDB(jankdb.OSFileSystem{}, dir, jankdb.StoreOptions{
+		SubDir:        "app",
+		EncryptionKey: "pass123",
+	})
+	names2, err := jankdb.Open[[]string](db2, "names")
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	if got := names2.Get(); len(got) != 1 || got[0] != "a" {
+		t.Errorf("expected flushed names [a], got %v", got)
+	}
+	_ = db2.Close()
+}
+
+func TestDB_InvalidName(t *testing.T) {
+	db, _ := jankdb.NewDB(jankdb.OSFileSystem{}, t.TempDir(), jankdb.StoreOptions{})
+	if _, err := jankdb.Open[int](db, "../escape"); !errors.Is(err, jankdb.ErrInvalidPath) {
+		t.Errorf("expected ErrInvalidPath, got %v", err)
+	}
+}
+
+func TestDB_ListSkipsShardFiles(t *testing.T) {
+	dir := t.TempDir()
+	fs := jankdb.OSFileSystem{}
+	sharded, _ := jankdb.NewShardedStore[int](fs, dir, 4, jankdb.StoreOptions{FileName: "users.json"})
+	_ = sharded.Set("alice", 1)
+	if err := sharded.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	db, _ := jankdb.NewDB(fs, dir, jankdb.StoreOptions{})
+	loot, _ := jankdb.Open[int](db, "loot")
+	if err := loot.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	list, err := db.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 1 || list[0] != "loot" {
+		t.Errorf("expected [loot], got %v", list)
+	}
+}
+
+func TestDB_CloseIsRetryable(t *testing.T) {
+	mockFS, files := newMemFS()
+	write := mockFS.WriteFileFunc
+	failing := true
+	mockFS.WriteFileFunc = func(path string, data []byte, perm os.FileMode) error {
+		if failing {
+			return errors.New("disk full")
+		}
+		return write(path, data, perm)
+	}
+	db, _ := jankdb.NewDB(mockFS, "/base", jankdb.StoreOptions{})
+	names, err := jankdb.Open[[]string](db, "names")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	names.Set([]string{"a"})
+
+	if err := db.Close(); err == nil {
+		t.Fatal("expected the failed flush to be reported")
+	}
+	failing = false
+	if err := db.Close(); err != nil {
+		t.Fatalf("retried Close failed: %v", err)
+	}
+	if string(files["/base/names.json"]) == "" {
+		t.Error("expected the retry to flush the store")
+	}
+	if err := db.Close(); !errors.Is(err, jankdb.ErrClosed) {
+		t.Errorf("expected ErrClosed once closed, got %v", err)
+	}
+}
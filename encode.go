@@ -0,0 +1,219 @@
+package jankdb
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// writeIndentedJSON writes exactly what json.MarshalIndent(v, prefix, "  ")
+// would, but without building it in memory: top-level maps and slices are
+// written one element at a time, so only the largest element is ever
+// marshaled whole. Anything else, structs included, is marshaled in one go:
+// streaming their fields would mean redoing encoding/json's field rules
+// (tags, omitempty, embedding), so a struct's largest field is the floor.
+func writeIndentedJSON(w io.Writer, v any, prefix string) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() && !customJSON(rv.Type()) {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() || customJSON(rv.Type()) {
+		return writeMarshaled(w, v, prefix)
+	}
+
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.IsNil() {
+			return writeMarshaled(w, v, prefix)
+		}
+		return writeMap(w, rv, prefix)
+	case reflect.Slice:
+		if rv.IsNil() || rv.Type().Elem().Kind() == reflect.Uint8 {
+			// nil and []byte (base64) have their own encodings
+			return writeMarshaled(w, v, prefix)
+		}
+		return writeList(w, rv, prefix)
+	case reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return writeMarshaled(w, v, prefix)
+		}
+		return writeList(w, rv, prefix)
+	default:
+		return writeMarshaled(w, v, prefix)
+	}
+}
+
+var (
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// customJSON reports whether t encodes itself, so its layout isn't ours to stream.
+func customJSON(t reflect.Type) bool {
+	return t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType)
+}
+
+// elementEncoder marshals one element at a time into a reused buffer.
+type elementEncoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+func newElementEncoder(prefix string) *elementEncoder {
+	e := &elementEncoder{}
+	e.enc = json.NewEncoder(&e.buf)
+	e.enc.SetIndent(prefix, "  ")
+	return e
+}
+
+func (e *elementEncoder) write(w io.Writer, v any) error {
+	e.buf.Reset()
+	if err := e.enc.Encode(v); err != nil {
+		return err
+	}
+	// Encode ends every value with a newline; MarshalIndent doesn't
+	_, err := w.Write(bytes.TrimSuffix(e.buf.Bytes(), []byte("\n")))
+	return err
+}
+
+func writeMarshaled(w io.Writer, v any, prefix string) error {
+	data, err := json.MarshalIndent(v, prefix, "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// writeMap writes a map's entries sorted by key, as encoding/json does.
+func writeMap(w io.Writer, rv reflect.Value, prefix string) error {
+	if rv.Len() == 0 {
+		_, err := io.WriteString(w, "{}")
+		return err
+	}
+
+	type entry struct {
+		name string
+		key  reflect.Value
+	}
+	entries := make([]entry, 0, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		name, err := jsonKeyName(iter.Key())
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{name: name, key: iter.Key()})
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return strings.Compare(a.name, b.name) })
+
+	inner := prefix + "  "
+	enc := newElementEncoder(inner)
+	if _, err := io.WriteString(w, "{\n"); err != nil {
+		return err
+	}
+	for i, e := range entries {
+		if _, err := io.WriteString(w, inner); err != nil {
+			return err
+		}
+		if err := enc.write(w, e.name); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, ": "); err != nil {
+			return err
+		}
+		if err := enc.write(w, rv.MapIndex(e.key).Interface()); err != nil {
+			return err
+		}
+		if err := writeSeparator(w, i == len(entries)-1); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, prefix+"}")
+	return err
+}
+
+// jsonKeyName turns a map key into its JSON object key, as encoding/json does.
+func jsonKeyName(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if k.Kind() == reflect.Pointer && k.IsNil() {
+			return "", nil
+		}
+		b, err := tm.MarshalText()
+		return string(b), err
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("unsupported map key type %s", k.Type())
+}
+
+func writeList(w io.Writer, rv reflect.Value, prefix string) error {
+	if rv.Len() == 0 {
+		_, err := io.WriteString(w, "[]")
+		return err
+	}
+
+	inner := prefix + "  "
+	enc := newElementEncoder(inner)
+	if _, err := io.WriteString(w, "[\n"); err != nil {
+		return err
+	}
+	for i := range rv.Len() {
+		elem := rv.Index(i)
+		v := elem.Interface()
+		if elem.CanAddr() {
+			// encoding/json sees slice elements as addressable, so
+			// pointer-receiver MarshalJSON methods apply to them
+			v = elem.Addr().Interface()
+		}
+		if _, err := io.WriteString(w, inner); err != nil {
+			return err
+		}
+		if err := enc.write(w, v); err != nil {
+			return err
+		}
+		if err := writeSeparator(w, i == rv.Len()-1); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, prefix+"]")
+	return err
+}
+
+func writeSeparator(w io.Writer, last bool) error {
+	sep := ",\n"
+	if last {
+		sep = "\n"
+	}
+	_, err := io.WriteString(w, sep)
+	return err
+}
+
+// writeEnvelope writes env the way writeIndentedJSON would, streaming its data.
+func writeEnvelope(w io.Writer, env envelope) error {
+	meta, err := json.MarshalIndent(env.Meta, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "{\n  \"jankdb\": "+string(meta)+",\n  \"data\": "); err != nil {
+		return err
+	}
+	if err := writeIndentedJSON(w, env.Data, "  "); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n}")
+	return err
+}
@@ -0,0 +1,163 @@
+package jankdb_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/metrics"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guarzo/jankdb"
+)
+
+type pointerMarshaler struct{ N int }
+
+func (p *pointerMarshaler) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`"n=%d"`, p.N)), nil
+}
+
+type textKey struct{ A, B string }
+
+func (k textKey) MarshalText() ([]byte, error) { return []byte(k.B + "/" + k.A), nil }
+
+// checkStreamedEncoding saves v with StreamWrites and expects exactly what
+// json.MarshalIndent produces.
+func checkStreamedEncoding[T any](t *testing.T, name string, v T) {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := jankdb.NewStore[T](jankdb.OSFileSystem{}, dir, jankdb.StoreOptions{FileName: "data.json", StreamWrites: true})
+	if err != nil {
+		t.Fatalf("%s: NewStore failed: %v", name, err)
+	}
+	s.Set(v)
+	if err := s.Save(); err != nil {
+		t.Fatalf("%s: Save failed: %v", name, err)
+	}
+	got, _ := os.ReadFile(filepath.Join(dir, "data.json"))
+	want, _ := json.MarshalIndent(v, "", "  ")
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s: streamed encoding differs\n got: %s\nwant: %s", name, got, want)
+	}
+}
+
+func TestStreamWrites_MatchesMarshalIndent(t *testing.T) {
+	checkStreamedEncoding(t, "string map", map[string]any{"b": []int{1, 2}, "a": map[string]int{"x": 1}, "<html>": "&"})
+	checkStreamedEncoding(t, "int keys", map[int]string{10: "ten", 2: "two", -1: "minus"})
+	checkStreamedEncoding(t, "text keys", map[textKey]int{{A: "1", B: "z"}: 1, {A: "2", B: "a"}: 2})
+	checkStreamedEncoding(t, "empty map", map[string]int{})
+	checkStreamedEncoding(t, "nil map", map[string]int(nil))
+	checkStreamedEncoding(t, "slice", []struct{ Name string }{{"a"}, {"b"}})
+	checkStreamedEncoding(t, "empty slice", []int{})
+	checkStreamedEncoding(t, "nil slice", []int(nil))
+	checkStreamedEncoding(t, "bytes", []byte("hello"))
+	checkStreamedEncoding(t, "array", [3]int{1, 2, 3})
+	checkStreamedEncoding(t, "pointer marshaler elements", []pointerMarshaler{{1}, {2}})
+	checkStreamedEncoding(t, "pointer to map", &map[string]int{"a": 1})
+	checkStreamedEncoding(t, "struct", struct {
+		When  time.Time
+		Items map[string]int
+	}{Items: map[string]int{"a": 1}})
+	checkStreamedEncoding(t, "scalar", 42)
+}
+
+func TestStreamWrites_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	opts := jankdb.StoreOptions{
+		FileName:       "data.json",
+		StreamWrites:   true,
+		Compression:    jankdb.FlateCompression,
+		TrackRevisions: true,
+	}
+	s, _ := jankdb.NewStore[map[string]int](jankdb.OSFileSystem{}, dir, opts)
+	s.Set(benchItems(100))
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// A store that doesn't stream reads the same file
+	opts.StreamWrites = false
+	loaded, _ := jankdb.NewStore[map[string]int](jankdb.OSFileSystem{}, dir, opts)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := loaded.Get(); len(got) != 100 || got["item-7"] != 7 {
+		t.Errorf("unexpected data after load: %v", got)
+	}
+	if loaded.Version() != s.Version() {
+		t.Errorf("expected version %+v after load, got %+v", s.Version(), loaded.Version())
+	}
+}
+
+// benchmarkSave saves a large map and reports, besides B/op, the highest
+// live heap seen during the saves (peak-heap-B) above what it was before.
+func benchmarkSave(b *testing.B, opts jankdb.StoreOptions) {
+	opts.FileName = "data.json"
+	s, _ := jankdb.NewStore[map[string]int](jankdb.OSFileSystem{}, b.TempDir(), opts)
+	items := make(map[string]int, 100_000)
+	for i := range 100_000 {
+		// Long values so the encoded file dwarfs per-element garbage
+		items[fmt.Sprintf("item-%d-%s", i, strings.Repeat("x", 100))] = i
+	}
+	s.Set(items)
+
+	runtime.GC()
+	before := heapInUse()
+	peak, stop := samplePeakHeap()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.Save(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	stop()
+	b.ReportMetric(float64(*peak-before), "peak-heap-B")
+}
+
+func heapInUse() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
+
+// samplePeakHeap records the highest heapInUse until stop is called.
+func samplePeakHeap() (*uint64, func()) {
+	peak := new(uint64)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(100 * time.Microsecond)
+		defer ticker.Stop()
+		for {
+			*peak = max(*peak, heapInUse())
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return peak, func() {
+		close(done)
+		<-finished
+	}
+}
+
+func BenchmarkSave_Buffered(b *testing.B) { benchmarkSave(b, jankdb.StoreOptions{}) }
+func BenchmarkSave_Streamed(b *testing.B) {
+	benchmarkSave(b, jankdb.StoreOptions{StreamWrites: true})
+}
+func BenchmarkSave_Buffered_Gzip(b *testing.B) {
+	benchmarkSave(b, jankdb.StoreOptions{Compression: jankdb.GzipCompression})
+}
+func BenchmarkSave_Streamed_Gzip(b *testing.B) {
+	benchmarkSave(b, jankdb.StoreOptions{Compression: jankdb.GzipCompression, StreamWrites: true})
+}
@@ -1,44 +1,70 @@
 package jankdb
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"path/filepath"
 
 	"golang.org/x/crypto/scrypt"
-	"io"
 )
 
+// binaryCipherMagic starts every EncryptBinary payload. Base64 text can't
+// contain a NUL, so binary and base64 files are told apart by the first byte.
+var binaryCipherMagic = []byte("\x00JDBE1")
+
 // EncryptData encrypts `plaintext` using a passphrase. Returns a base64-encoded ciphertext.
 func EncryptData(passphrase string, plaintext []byte) (string, error) {
+	payload, err := encryptRaw(passphrase, plaintext)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(payload), nil
+}
+
+// EncryptBinary is EncryptData without the base64: it returns a short header
+// followed by the raw salt, nonce and ciphertext, a third smaller than EncryptData's output.
+func EncryptBinary(passphrase string, plaintext []byte) ([]byte, error) {
+	payload, err := encryptRaw(passphrase, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(nil), binaryCipherMagic...), payload...), nil
+}
+
+// encryptRaw returns salt || nonce || ciphertext.
+func encryptRaw(passphrase string, plaintext []byte) ([]byte, error) {
 	// 1. Generate salt
 	salt := make([]byte, 16)
 	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
-		return "", fmt.Errorf("failed to read random salt: %w", err)
+		return nil, fmt.Errorf("failed to read random salt: %w", err)
 	}
 
 	// 2. Derive key from passphrase + salt using scrypt
 	key, err := scrypt.Key([]byte(passphrase), salt, 32768, 8, 1, 32)
 	if err != nil {
-		return "", fmt.Errorf("failed to derive key: %w", err)
+		return nil, fmt.Errorf("failed to derive key: %w", err)
 	}
 
 	// 3. Create AES-GCM cipher
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return "", fmt.Errorf("failed to create cipher block: %w", err)
+		return nil, fmt.Errorf("failed to create cipher block: %w", err)
 	}
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		return "", fmt.Errorf("failed to create GCM: %w", err)
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
 	}
 
 	// 4. Generate nonce
 	nonce := make([]byte, gcm.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return "", fmt.Errorf("failed to read random nonce: %w", err)
+		return nil, fmt.Errorf("failed to read random nonce: %w", err)
 	}
 
 	// 5. Seal
@@ -47,9 +73,7 @@ func EncryptData(passphrase string, plaintext []byte) (string, error) {
 	// 6. Final payload = salt || nonce || ciphertext
 	payload := append(salt, nonce...)
 	payload = append(payload, ciphertext...)
-
-	// 7. Return base64-encoded
-	return base64.StdEncoding.EncodeToString(payload), nil
+	return payload, nil
 }
 
 // DecryptData decrypts a base64-encoded ciphertext string using the passphrase.
@@ -60,6 +84,19 @@ func DecryptData(passphrase, base64CipherText string) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to base64-decode ciphertext: %w", err)
 	}
+	return decryptRaw(passphrase, payload)
+}
+
+// DecryptBinary decrypts the output of EncryptBinary.
+func DecryptBinary(passphrase string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, binaryCipherMagic) {
+		return nil, errors.New("missing binary ciphertext header")
+	}
+	return decryptRaw(passphrase, data[len(binaryCipherMagic):])
+}
+
+// decryptRaw opens salt || nonce || ciphertext.
+func decryptRaw(passphrase string, payload []byte) ([]byte, error) {
 	if len(payload) < 16 {
 		return nil, fmt.Errorf("payload too short to contain salt")
 	}
@@ -100,3 +137,65 @@ func DecryptData(passphrase, base64CipherText string) ([]byte, error) {
 
 	return plaintext, nil
 }
+
+// decryptAny decrypts data in any on-disk format: stream, binary or base64.
+func decryptAny(passphrase string, data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, binaryCipherMagic) {
+		return DecryptBinary(passphrase, data)
+	}
+	if bytes.HasPrefix(data, streamCipherMagic) {
+		r, err := NewDecryptReader(bytes.NewReader(data), passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+	return DecryptData(passphrase, string(data))
+}
+
+// CipherFormat selects how encrypted stores are written. Load reads every format.
+type CipherFormat int
+
+const (
+	// CipherBase64 writes base64 text (the original format, and the default).
+	CipherBase64 CipherFormat = iota
+	// CipherBinary writes raw bytes behind a header, see EncryptBinary.
+	CipherBinary
+	// CipherStream encrypts while writing and decrypts while reading, in
+	// 64 KiB segments, so the ciphertext is never held in memory whole.
+	// See NewEncryptWriter.
+	CipherStream
+)
+
+// ConvertToBinaryCiphertext rewrites the encrypted file of the store that
+// NewStore(fs, basePath, opts) would open from base64 to the binary format in
+// place, written as Save would (Backup, Durable, FileMode, Owner). It doesn't
+// need the passphrase. Files that are already binary are left alone; files
+// in the stream format can't be converted without it, so they're an error.
+func ConvertToBinaryCiphertext(fs FileSystem, basePath string, opts StoreOptions) error {
+	if err := validatePathOptions(fs, opts); err != nil {
+		return err
+	}
+	path := filepath.Join(basePath, opts.SubDir, opts.FileName)
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	switch {
+	case bytes.HasPrefix(data, binaryCipherMagic):
+		return nil
+	case bytes.HasPrefix(data, streamCipherMagic):
+		return fmt.Errorf("%s is stream ciphertext; load and save it with CipherBinary to convert it", path)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return fmt.Errorf("file is not base64 ciphertext: %w", err)
+	}
+	converted := append(append([]byte(nil), binaryCipherMagic...), payload...)
+	wopts := writeOptions{backup: opts.EnableBackup, durable: opts.Durable, perm: opts.FileMode, owner: opts.Owner}
+	if err := atomicWriteFile(fs, path, converted, wopts); err != nil {
+		return fmt.Errorf("failed to write converted file: %w", err)
+	}
+	return nil
+}
@@ -40,3 +40,98 @@ func TestDecryptData_BadPassphrase(t *testing.T) {
 		t.Error("expected error when decrypting with wrong passphrase, got nil")
 	}
 }
+
+func TestEncryptDecryptBinary(t *testing.T) {
+	pass := "testpass"
+	plain := []byte("secret message")
+
+	encrypted, err := jankdb.EncryptBinary(pass, plain)
+	if err != nil {
+		t.Fatalf("EncryptBinary failed: %v", err)
+	}
+	b64, _ := jankdb.EncryptData(pass, plain)
+	if len(encrypted) >= len(b64) {
+		t.Errorf("expected binary (%d bytes) to be smaller than base64 (%d bytes)", len(encrypted), len(b64))
+	}
+
+	decrypted, err := jankdb.DecryptBinary(pass, encrypted)
+	if err != nil {
+		t.Fatalf("DecryptBinary failed: %v", err)
+	}
+	if string(decrypted) != string(plain) {
+		t.Errorf("expected %s, got %s", plain, decrypted)
+	}
+}
+
+func TestStore_CipherBinary(t *testing.T) {
+	mockFS, files := newMemFS()
+	s, _ := jankdb.NewStore[string](mockFS, "/base", jankdb.StoreOptions{
+		FileName:      "s.json",
+		EncryptionKey: "pass123",
+		CipherFormat:  jankdb.CipherBinary,
+	})
+	s.Set("top-secret")
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if files["/base/s.json"][0] != 0 {
+		t.Error("expected binary header on disk")
+	}
+
+	// Default-format stores still read binary files
+	s2, _ := jankdb.NewStore[string](mockFS, "/base", jankdb.StoreOptions{
+		FileName:      "s.json",
+		EncryptionKey: "pass123",
+	})
+	if err := s2.Load(); err != nil || s2.Get() != "top-secret" {
+		t.Errorf("expected binary file to load, got %q (%v)", s2.Get(), err)
+	}
+}
+
+func TestConvertToBinaryCiphertext(t *testing.T) {
+	mockFS, files := newMemFS()
+	opts := jankdb.StoreOptions{FileName: "s.json", EncryptionKey: "pass123"}
+	s, _ := jankdb.NewStore[string](mockFS, "/base", opts)
+	s.Set("top-secret")
+	_ = s.Save()
+	before := len(files["/base/s.json"])
+
+	if err := jankdb.ConvertToBinaryCiphertext(mockFS, "/base", opts); err != nil {
+		t.Fatalf("ConvertToBinaryCiphertext failed: %v", err)
+	}
+	if after := len(files["/base/s.json"]); after >= before {
+		t.Errorf("expected converted file to shrink, %d -> %d", before, after)
+	}
+	if err := jankdb.ConvertToBinaryCiphertext(mockFS, "/base", opts); err != nil {
+		t.Errorf("expected converting twice to be a no-op, got %v", err)
+	}
+
+	s2, _ := jankdb.NewStore[string](mockFS, "/base", opts)
+	if err := s2.Load(); err != nil || s2.Get() != "top-secret" {
+		t.Errorf("expected converted file to load, got %q (%v)", s2.Get(), err)
+	}
+}
+
+func TestConvertToBinaryCiphertext_Options(t *testing.T) {
+	mockFS, files := newMemFS()
+	opts := jankdb.StoreOptions{FileName: "s.json", EncryptionKey: "pass123", EnableBackup: true}
+	s, _ := jankdb.NewStore[string](mockFS, "/base", opts)
+	s.Set("top-secret")
+	_ = s.Save()
+	base64File := string(files["/base/s.json"])
+
+	if err := jankdb.ConvertToBinaryCiphertext(mockFS, "/base", opts); err != nil {
+		t.Fatalf("ConvertToBinaryCiphertext failed: %v", err)
+	}
+	if string(files["/base/s.json.bak"]) != base64File {
+		t.Error("expected EnableBackup to keep the base64 file as .bak")
+	}
+
+	// Only the header matters here
+	files["/base/big.json"] = []byte("\x00JDBS1 segments")
+	stream := jankdb.StoreOptions{FileName: "big.json", EncryptionKey: "pass123", CipherFormat: jankdb.CipherStream}
+	err := jankdb.ConvertToBinaryCiphertext(mockFS, "/base", stream)
+	if err == nil || !strings.Contains(err.Error(), "stream") {
+		t.Errorf("expected stream ciphertext to be refused by name, got %v", err)
+	}
+}
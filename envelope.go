@@ -0,0 +1,41 @@
+package jankdb
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// envelope is the on-disk wrapper used once a SchemaVersion is set or
+// revisions are tracked.
+type envelope struct {
+	Meta envelopeMeta `json:"jankdb"`
+	Data any          `json:"data"`
+}
+
+type envelopeMeta struct {
+	Schema   int    `json:"schema"`
+	Revision uint64 `json:"revision,omitempty"`
+}
+
+// useEnvelope reports whether this store wraps its data in an envelope.
+func (s *Store[T]) useEnvelope() bool {
+	return s.schemaVersion > 0 || s.trackRevisions
+}
+
+// unwrapEnvelope returns the data and metadata from plaintext JSON.
+// Anything that isn't an envelope is returned as-is with zero metadata (schema version 0).
+func unwrapEnvelope(plaintext []byte) (json.RawMessage, envelopeMeta) {
+	trimmed := bytes.TrimSpace(plaintext)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return plaintext, envelopeMeta{}
+	}
+
+	var env struct {
+		Meta *envelopeMeta   `json:"jankdb"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(trimmed, &env); err != nil || env.Meta == nil || env.Data == nil {
+		return plaintext, envelopeMeta{}
+	}
+	return env.Data, *env.Meta
+}
@@ -1,8 +1,10 @@
 package jankdb
 
 import (
+	"context"
 	"io"
 	"os"
+	"runtime"
 )
 
 // FileSystem is your abstraction over the file system.
@@ -20,6 +22,22 @@ type FileSystem interface {
 	Rename(src, dst string) error
 }
 
+// ContextFileSystem is a FileSystem that can honour a context, e.g. to abort
+// slow network I/O. The Store's *Context methods call WithContext and use the
+// result for that one operation; other file systems just get a ctx check
+// before each call.
+type ContextFileSystem interface {
+	FileSystem
+	WithContext(ctx context.Context) FileSystem
+}
+
+// Syncer is implemented by file systems that can flush a file or a directory
+// entry to stable storage. Stores created with Durable use it around each write.
+type Syncer interface {
+	SyncFile(path string) error
+	SyncDir(dir string) error
+}
+
 // OSFileSystem is a real implementation that calls the `os` package.
 type OSFileSystem struct{}
 
@@ -56,3 +74,39 @@ func (OSFileSystem) Create(dir string) (*os.File, error) {
 func (OSFileSystem) Rename(src, dst string) error {
 	return os.Rename(src, dst)
 }
+func (OSFileSystem) SyncFile(path string) error {
+	// Read-only is enough for fsync, and works for files written 0400
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+func (OSFileSystem) SyncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		// Directories can't be fsynced on Windows; renames are journaled anyway.
+		return nil
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+func (OSFileSystem) Chmod(path string, mode os.FileMode) error {
+	return os.Chmod(path, mode)
+}
+func (OSFileSystem) Chown(path string, uid, gid int) error {
+	return os.Chown(path, uid, gid)
+}
+func (OSFileSystem) Lstat(path string) (os.FileInfo, error) {
+	return os.Lstat(path)
+}
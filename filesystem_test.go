@@ -3,6 +3,7 @@ package jankdb_test
 import (
 	"errors"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/guarzo/jankdb"
@@ -41,3 +42,19 @@ func TestOSFileSystem_Stat(t *testing.T) {
 		t.Error("expected file to not exist, but got no error")
 	}
 }
+
+func TestOSFileSystem_Sync(t *testing.T) {
+	fs := jankdb.OSFileSystem{}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "synced.txt")
+
+	if err := fs.WriteFile(path, []byte("data"), 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := fs.SyncFile(path); err != nil {
+		t.Errorf("SyncFile failed: %v", err)
+	}
+	if err := fs.SyncDir(dir); err != nil {
+		t.Errorf("SyncDir failed: %v", err)
+	}
+}
@@ -0,0 +1,612 @@
+package jankdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"time"
+)
+
+// ErrNoHistory is returned by At when no recorded version is old enough.
+var ErrNoHistory = errors.New("no history at that time")
+
+// defaultSnapshotEvery is HistoryOptions.SnapshotEvery when unset.
+const defaultSnapshotEvery = 10
+
+// HistoryOptions turns on a store's change history: every Save appends the
+// new version to FileName.history, so past versions can be read back with
+// History and At. The log assumes a single writing process. It is appended
+// to through FileSystem.OpenFile; a FileSystem that can't return a real file
+// should fail it with errors.ErrUnsupported, and each Save then rewrites
+// the whole log instead, which works but costs more as it grows.
+type HistoryOptions struct {
+	// Every SnapshotEvery-th entry holds the whole value (default 10); the
+	// ones in between hold a JSON Patch against the entry before.
+	SnapshotEvery int
+
+	// Retention. When either is set, entries older than MaxAge and all but
+	// the newest MaxEntries are dropped each time a snapshot is written (or
+	// on CompactHistory). The newest entry is always kept. 0 => no limit.
+	MaxAge     time.Duration
+	MaxEntries int
+}
+
+// HistoryRecord is one saved version of a store's data.
+type HistoryRecord[T any] struct {
+	Time     time.Time
+	Revision uint64
+	Author   string
+	Message  string
+	Value    T
+}
+
+// historyEntry is one line of the history file.
+type historyEntry struct {
+	Time     time.Time       `json:"time"`
+	Revision uint64          `json:"revision,omitempty"`
+	Author   string          `json:"author,omitempty"`
+	Message  string          `json:"message,omitempty"`
+	Snapshot json.RawMessage `json:"snapshot,omitempty"`
+	Patch    json.RawMessage `json:"patch,omitempty"`
+}
+
+type historyKey int
+
+const (
+	authorKey historyKey = iota
+	messageKey
+)
+
+// WithAuthor returns a context that makes SaveContext (and the other
+// Context methods that save) record author in the history entry.
+func WithAuthor(ctx context.Context, author string) context.Context {
+	return context.WithValue(ctx, authorKey, author)
+}
+
+// WithMessage is WithAuthor for a message describing the change.
+func WithMessage(ctx context.Context, message string) context.Context {
+	return context.WithValue(ctx, messageKey, message)
+}
+
+func (s *Store[T]) historyPath() string {
+	return s.filePath() + ".history"
+}
+
+// appendHistory records the data just saved; the caller must hold s.mu.
+// Saving data identical to the previous entry records nothing.
+func (s *Store[T]) appendHistory(ctx context.Context, fs FileSystem, revision uint64) error {
+	current, err := json.Marshal(s.data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data for history: %w", err)
+	}
+	if s.historyPrev != nil && bytes.Equal(current, s.historyPrev) {
+		return nil
+	}
+
+	entry := historyEntry{Time: time.Now().UTC(), Revision: revision}
+	entry.Author, _ = ctx.Value(authorKey).(string)
+	if entry.Author == "" {
+		entry.Author, _ = ctx.Value(actorKey).(string)
+	}
+	entry.Message, _ = ctx.Value(messageKey).(string)
+
+	every := s.history.SnapshotEvery
+	if every <= 0 {
+		every = defaultSnapshotEvery
+	}
+	// We only know the previous entry if we wrote it, so the first entry
+	// after a Load is always a snapshot
+	snapshot := s.historyPrev == nil || s.historySince+1 >= every
+	if snapshot {
+		entry.Snapshot = current
+	} else {
+		prev, err := decodeJSONValue(s.historyPrev)
+		if err != nil {
+			return err
+		}
+		cur, err := decodeJSONValue(current)
+		if err != nil {
+			return err
+		}
+		if entry.Patch, err = diffJSONValues(prev, cur); err != nil {
+			return err
+		}
+	}
+
+	seal, err := s.appendSeal(fs)
+	if err != nil {
+		return err
+	}
+	line, err := encodeHistoryEntry(seal, entry)
+	if err != nil {
+		return err
+	}
+	if err := appendLine(fs, s.historyPath(), line, s.writeOptions()); err != nil {
+		return fmt.Errorf("failed to append history: %w", err)
+	}
+	s.historyPrev = current
+
+	if !snapshot {
+		s.historySince++
+		return nil
+	}
+	s.historySince = 0
+	if s.history.MaxAge > 0 || s.history.MaxEntries > 0 {
+		return s.compactHistory(fs)
+	}
+	return nil
+}
+
+// appendLine appends line and a newline to the file at path, creating it
+// with opts.perm and opts.owner if needed. If fs has no real files to hand
+// out (OpenFile returns errors.ErrUnsupported, or not-exist, which O_CREATE
+// rules out on a real file system), the file is rewritten with the line
+// added instead.
+func appendLine(fs FileSystem, path string, line []byte, opts writeOptions) error {
+	_, statErr := fs.Stat(path)
+
+	f, err := fs.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, opts.perm)
+	if (err == nil && f == nil) || errors.Is(err, errors.ErrUnsupported) || fs.IsNotExist(err) {
+		return rewriteWithLine(fs, path, line, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	// One write per line, so concurrent readers never see half a line
+	// unless we crash mid-write; readers skip a torn last line
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to append to %s: %w", path, err)
+	}
+	if opts.durable {
+		if err := f.Sync(); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("failed to sync %s: %w", path, err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to append to %s: %w", path, err)
+	}
+	if fs.IsNotExist(statErr) {
+		return applyPermissions(fs, path, opts.perm, opts.owner)
+	}
+	return nil
+}
+
+// rewriteWithLine is appendLine for file systems without OpenFile: it
+// replaces the file with its old content plus line, atomically.
+func rewriteWithLine(fs FileSystem, path string, line []byte, opts writeOptions) error {
+	data, err := fs.ReadFile(path)
+	if err != nil && !fs.IsNotExist(err) {
+		return err
+	}
+	data = append(append(data, line...), '\n')
+	opts.backup = false
+	return atomicWriteFile(fs, path, data, opts)
+}
+
+// historyHeaderVersion marks the first line of an encrypted history file.
+const historyHeaderVersion = 1
+
+// historyHeader is the first line of an encrypted store's history file. The
+// key for every line is derived from Salt, so scrypt runs once per file
+// rather than once per line.
+type historyHeader struct {
+	Version int    `json:"jankdb_history"`
+	Salt    []byte `json:"salt"`
+}
+
+// historySeal encrypts the lines of one history file.
+type historySeal struct {
+	header []byte // the header line
+	salt   []byte
+	aead   cipher.AEAD
+}
+
+// historyFile reads a store's history file. It carries what that needs
+// from the store, so History and At can read without holding its lock.
+type historyFile struct {
+	fs   FileSystem
+	path string
+	key  string       // the store's encryption key, "" if none
+	seal *historySeal // the last seal used, kept while the salt matches
+}
+
+// historyFile returns the store's history file as read through fs; the
+// caller must hold s.mu, at least for reading.
+func (s *Store[T]) historyFile(fs FileSystem) *historyFile {
+	return &historyFile{fs: fs, path: s.historyPath(), key: s.encryptionKey, seal: s.historySeal}
+}
+
+// sealFor returns the seal for a history file with the given salt. The key
+// is only derived again when the salt changes.
+func (h *historyFile) sealFor(salt []byte) (*historySeal, error) {
+	if h.seal != nil && bytes.Equal(h.seal.salt, salt) {
+		return h.seal, nil
+	}
+	aead, err := streamAEAD(h.key, salt)
+	if err != nil {
+		return nil, err
+	}
+	header, err := json.Marshal(historyHeader{Version: historyHeaderVersion, Salt: salt})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal history header: %w", err)
+	}
+	h.seal = &historySeal{header: header, salt: salt, aead: aead}
+	return h.seal, nil
+}
+
+// newSeal returns a seal with a fresh salt, for a new history file.
+func (h *historyFile) newSeal() (*historySeal, error) {
+	salt := make([]byte, streamSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("failed to read random salt: %w", err)
+	}
+	return h.sealFor(salt)
+}
+
+func (h *historyFile) parseHeader(line []byte) (*historySeal, error) {
+	var hdr historyHeader
+	if err := json.Unmarshal(line, &hdr); err != nil || hdr.Version != historyHeaderVersion || len(hdr.Salt) == 0 {
+		return nil, errors.New("encrypted history has no valid header")
+	}
+	return h.sealFor(hdr.Salt)
+}
+
+// newHistorySeal returns a seal with a fresh salt, keeping it so the
+// store's next read of the file doesn't derive the key again; the caller
+// must hold s.mu.
+func (s *Store[T]) newHistorySeal() (*historySeal, error) {
+	h := s.historyFile(s.fs)
+	seal, err := h.newSeal()
+	s.historySeal = h.seal
+	return seal, err
+}
+
+// appendSeal returns the seal to append to the history file with (nil if
+// the store isn't encrypted), starting the file with a header if it has
+// none; the caller must hold s.mu.
+func (s *Store[T]) appendSeal(fs FileSystem) (*historySeal, error) {
+	if s.encryptionKey == "" {
+		return nil, nil
+	}
+	h := s.historyFile(fs)
+	defer func() { s.historySeal = h.seal }()
+
+	var first []byte
+	err := h.readLines(func(line []byte) (bool, error) {
+		first = line
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if first != nil {
+		return h.parseHeader(first)
+	}
+
+	// A new file, or one torn before its header was complete
+	seal, err := h.newSeal()
+	if err != nil {
+		return nil, err
+	}
+	opts := s.writeOptions()
+	opts.backup = false
+	if err := atomicWriteFile(fs, h.path, append(append([]byte(nil), seal.header...), '\n'), opts); err != nil {
+		return nil, fmt.Errorf("failed to write history header: %w", err)
+	}
+	return seal, nil
+}
+
+// encodeHistoryEntry returns one line of the history file. With a seal, the
+// line is the base64 of nonce || AES-GCM ciphertext of the entry.
+func encodeHistoryEntry(seal *historySeal, entry historyEntry) ([]byte, error) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal history entry: %w", err)
+	}
+	if seal == nil {
+		return line, nil
+	}
+	nonce := make([]byte, seal.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to read random nonce: %w", err)
+	}
+	sealed := seal.aead.Seal(nonce, nonce, line, nil)
+	return []byte(base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+func decodeHistoryEntry(seal *historySeal, line []byte) (historyEntry, error) {
+	var entry historyEntry
+	if seal != nil {
+		raw, err := base64.StdEncoding.DecodeString(string(line))
+		if err != nil {
+			return entry, fmt.Errorf("failed to decode history entry: %w", err)
+		}
+		n := seal.aead.NonceSize()
+		if len(raw) < n {
+			return entry, errors.New("failed to decrypt history entry: too short")
+		}
+		if line, err = seal.aead.Open(nil, raw[:n], raw[n:], nil); err != nil {
+			return entry, fmt.Errorf("failed to decrypt history entry: %w", err)
+		}
+	}
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return entry, fmt.Errorf("failed to unmarshal history entry: %w", err)
+	}
+	return entry, nil
+}
+
+// readHistory calls fn with each entry of the history file and its line as
+// stored, oldest first. It returns the file's seal (nil if the store isn't
+// encrypted or there is no file). The caller must hold s.mu.
+func (s *Store[T]) readHistory(fs FileSystem, fn func(line []byte, entry historyEntry) (bool, error)) (*historySeal, error) {
+	h := s.historyFile(fs)
+	seal, err := h.read(fn)
+	s.historySeal = h.seal
+	return seal, err
+}
+
+// replayHistory is historyFile.replay; the caller must hold s.mu.
+func (s *Store[T]) replayHistory(fs FileSystem, fn func(e historyEntry, doc any) (bool, error)) error {
+	h := s.historyFile(fs)
+	err := h.replay(fn)
+	s.historySeal = h.seal
+	return err
+}
+
+// read calls fn with each entry of the file and its line as stored, oldest
+// first. It returns the file's seal (nil if unencrypted or missing).
+func (h *historyFile) read(fn func(line []byte, entry historyEntry) (bool, error)) (*historySeal, error) {
+	var seal *historySeal
+	header := h.key != ""
+	err := h.readLines(func(line []byte) (bool, error) {
+		if header {
+			header = false
+			var err error
+			seal, err = h.parseHeader(line)
+			return err == nil, err
+		}
+		entry, err := decodeHistoryEntry(seal, line)
+		if err != nil {
+			return false, err
+		}
+		return fn(line, entry)
+	})
+	return seal, err
+}
+
+// readLines calls fn with each complete line of the file, oldest first.
+func (h *historyFile) readLines(fn func(line []byte) (bool, error)) error {
+	f, err := h.fs.Open(h.path)
+	if h.fs.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// No newline: an append that never finished
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read history: %w", err)
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if more, err := fn(line); err != nil || !more {
+			return err
+		}
+	}
+}
+
+// replay calls fn with each entry and the JSON value it describes.
+func (h *historyFile) replay(fn func(e historyEntry, doc any) (bool, error)) error {
+	var doc any
+	started := false
+	_, err := h.read(func(_ []byte, entry historyEntry) (bool, error) {
+		var err error
+		switch {
+		case entry.Snapshot != nil:
+			if doc, err = decodeJSONValue(entry.Snapshot); err != nil {
+				return false, err
+			}
+		case !started:
+			return false, errors.New("history starts with a patch instead of a snapshot")
+		default:
+			var ops []patchOp
+			if err := json.Unmarshal(entry.Patch, &ops); err != nil {
+				return false, fmt.Errorf("failed to unmarshal history patch: %w", err)
+			}
+			if doc, err = applyPatch(doc, ops); err != nil {
+				return false, fmt.Errorf("failed to replay history at %s: %w", entry.Time.Format(time.RFC3339), err)
+			}
+		}
+		started = true
+		return fn(entry, doc)
+	})
+	return err
+}
+
+// readableHistory returns the history file for History and At to read
+// after the lock is released, or ErrClosed.
+func (s *Store[T]) readableHistory() (*historyFile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	return s.historyFile(s.fs), nil
+}
+
+func historyRecord[T any](entry historyEntry, doc any) (HistoryRecord[T], error) {
+	rec := HistoryRecord[T]{Time: entry.Time, Revision: entry.Revision, Author: entry.Author, Message: entry.Message}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return rec, fmt.Errorf("failed to marshal history value: %w", err)
+	}
+	if err := json.Unmarshal(raw, &rec.Value); err != nil {
+		return rec, fmt.Errorf("failed to unmarshal history value: %w", err)
+	}
+	return rec, nil
+}
+
+// History iterates over the saved versions of the data, oldest first. The
+// log is read as it goes, so breaking out early is cheap, and without the
+// store's lock, so the loop may use the store. An error ends the iteration;
+// after Close it is ErrClosed.
+//
+//	for rec, err := range store.History() {
+//		if err != nil { ... }
+//		fmt.Println(rec.Time, rec.Author, rec.Value)
+//	}
+func (s *Store[T]) History() iter.Seq2[HistoryRecord[T], error] {
+	return func(yield func(HistoryRecord[T], error) bool) {
+		h, err := s.readableHistory()
+		if err == nil {
+			err = h.replay(func(entry historyEntry, doc any) (bool, error) {
+				rec, err := historyRecord[T](entry, doc)
+				if err != nil {
+					return false, err
+				}
+				return yield(rec, nil), nil
+			})
+		}
+		if err != nil {
+			var zero HistoryRecord[T]
+			yield(zero, err)
+		}
+	}
+}
+
+// At returns the data as it was saved at time t: the newest recorded version
+// no later than t. It returns ErrNoHistory if there is none, and ErrClosed
+// after Close.
+func (s *Store[T]) At(t time.Time) (T, error) {
+	var (
+		last  historyEntry
+		doc   any
+		found bool
+		zero  T
+	)
+	h, err := s.readableHistory()
+	if err != nil {
+		return zero, err
+	}
+	err = h.replay(func(entry historyEntry, d any) (bool, error) {
+		if entry.Time.After(t) {
+			return false, nil
+		}
+		// Later patches change the value in place
+		last, doc, found = entry, copyJSONValue(d), true
+		return true, nil
+	})
+	if err != nil {
+		return zero, err
+	}
+	if !found {
+		return zero, fmt.Errorf("%w: %s", ErrNoHistory, t.Format(time.RFC3339))
+	}
+	rec, err := historyRecord[T](last, doc)
+	if err != nil {
+		return zero, err
+	}
+	return rec.Value, nil
+}
+
+// CompactHistory applies the HistoryOptions retention policy now.
+func (s *Store[T]) CompactHistory() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.history == nil {
+		return nil
+	}
+	return s.compactHistory(s.fs)
+}
+
+// compactHistory drops entries outside the retention policy, turning the
+// oldest one kept into a snapshot; the caller must hold s.mu.
+func (s *Store[T]) compactHistory(fs FileSystem) error {
+	var lines [][]byte
+	var times []time.Time
+	seal, err := s.readHistory(fs, func(line []byte, entry historyEntry) (bool, error) {
+		lines = append(lines, append([]byte(nil), line...))
+		times = append(times, entry.Time)
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	keepFrom := 0
+	if limit := s.history.MaxEntries; limit > 0 && len(lines) > limit {
+		keepFrom = len(lines) - limit
+	}
+	if s.history.MaxAge > 0 {
+		cutoff := time.Now().Add(-s.history.MaxAge)
+		for keepFrom < len(lines)-1 && times[keepFrom].Before(cutoff) {
+			keepFrom++
+		}
+	}
+	if keepFrom == 0 {
+		return nil
+	}
+
+	// Rebuild the first entry kept as a snapshot; the patches after it
+	// still apply on top of it unchanged
+	var first historyEntry
+	n := 0
+	err = s.replayHistory(fs, func(entry historyEntry, doc any) (bool, error) {
+		if n < keepFrom {
+			n++
+			return true, nil
+		}
+		snapshot, err := json.Marshal(doc)
+		if err != nil {
+			return false, fmt.Errorf("failed to marshal history snapshot: %w", err)
+		}
+		first = entry
+		first.Snapshot, first.Patch = snapshot, nil
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+	line, err := encodeHistoryEntry(seal, first)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	if seal != nil {
+		out.Write(seal.header)
+		out.WriteByte('\n')
+	}
+	for _, l := range append([][]byte{line}, lines[keepFrom+1:]...) {
+		out.Write(l)
+		out.WriteByte('\n')
+	}
+	opts := s.writeOptions()
+	opts.backup = false
+	if err := atomicWriteFile(fs, s.historyPath(), out.Bytes(), opts); err != nil {
+		return fmt.Errorf("failed to write compacted history: %w", err)
+	}
+	return nil
+}
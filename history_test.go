@@ -0,0 +1,246 @@
+package jankdb_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guarzo/jankdb"
+)
+
+type historyConfig struct {
+	Theme  string         `json:"theme"`
+	Limits map[string]int `json:"limits"`
+}
+
+func TestStore_History(t *testing.T) {
+	dir := t.TempDir()
+	opts := jankdb.StoreOptions{
+		FileName:       "config.json",
+		TrackRevisions: true,
+		History:        &jankdb.HistoryOptions{SnapshotEvery: 3},
+	}
+	s, _ := jankdb.NewStore[historyConfig](jankdb.OSFileSystem{}, dir, opts)
+
+	themes := []string{"light", "dark", "blue", "red", "green"}
+	var times []time.Time
+	for i, theme := range themes {
+		s.Set(historyConfig{Theme: theme, Limits: map[string]int{"rps": i}})
+		ctx := jankdb.WithMessage(jankdb.WithAuthor(context.Background(), "alice"), "set "+theme)
+		if err := s.SaveContext(ctx); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		times = append(times, time.Now())
+		time.Sleep(5 * time.Millisecond)
+	}
+	// Saving unchanged data records nothing
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	var got []string
+	for rec, err := range s.History() {
+		if err != nil {
+			t.Fatalf("History failed: %v", err)
+		}
+		if rec.Author != "alice" || rec.Message != "set "+rec.Value.Theme {
+			t.Errorf("unexpected author/message %q/%q", rec.Author, rec.Message)
+		}
+		got = append(got, rec.Value.Theme)
+	}
+	if strings.Join(got, ",") != strings.Join(themes, ",") {
+		t.Errorf("expected history %v, got %v", themes, got)
+	}
+
+	// Snapshots and patches both reconstruct correctly
+	for i, when := range times {
+		v, err := s.At(when)
+		if err != nil {
+			t.Fatalf("At failed: %v", err)
+		}
+		if v.Theme != themes[i] || v.Limits["rps"] != i {
+			t.Errorf("At(%d) = %+v, want %s", i, v, themes[i])
+		}
+	}
+	if _, err := s.At(times[0].Add(-time.Hour)); !errors.Is(err, jankdb.ErrNoHistory) {
+		t.Errorf("expected ErrNoHistory before the first save, got %v", err)
+	}
+
+	// A torn last line (crash mid-append) is ignored
+	f, _ := os.OpenFile(filepath.Join(dir, "config.json.history"), os.O_APPEND|os.O_WRONLY, 0)
+	_, _ = f.WriteString(`{"time":"2030-01-01T00:00:00Z","pat`)
+	_ = f.Close()
+	if v, err := s.At(time.Now()); err != nil || v.Theme != "green" {
+		t.Errorf("expected latest version despite torn line, got %+v, %v", v, err)
+	}
+}
+
+func TestStore_History_Compaction(t *testing.T) {
+	dir := t.TempDir()
+	opts := jankdb.StoreOptions{
+		FileName:      "config.json",
+		EncryptionKey: "pass",
+		History:       &jankdb.HistoryOptions{SnapshotEvery: 4, MaxEntries: 3},
+	}
+	s, _ := jankdb.NewStore[historyConfig](jankdb.OSFileSystem{}, dir, opts)
+	for i := range 6 {
+		s.Set(historyConfig{Theme: "v", Limits: map[string]int{"n": i}})
+		if err := s.Save(); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	if err := s.CompactHistory(); err != nil {
+		t.Fatalf("CompactHistory failed: %v", err)
+	}
+
+	var got []int
+	for rec, err := range s.History() {
+		if err != nil {
+			t.Fatalf("History failed: %v", err)
+		}
+		got = append(got, rec.Value.Limits["n"])
+	}
+	if len(got) != 3 || got[0] != 3 || got[2] != 5 {
+		t.Errorf("expected the newest 3 versions, got %v", got)
+	}
+
+	raw, _ := os.ReadFile(filepath.Join(dir, "config.json.history"))
+	if strings.Contains(string(raw), "limits") {
+		t.Error("expected encrypted history lines")
+	}
+}
+
+func TestStore_History_EncryptedHeader(t *testing.T) {
+	dir := t.TempDir()
+	opts := jankdb.StoreOptions{
+		FileName:      "config.json",
+		EncryptionKey: "pass",
+		History:       &jankdb.HistoryOptions{},
+	}
+	s, _ := jankdb.NewStore[historyConfig](jankdb.OSFileSystem{}, dir, opts)
+	for _, theme := range []string{"light", "dark"} {
+		s.Set(historyConfig{Theme: theme})
+		if err := s.Save(); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	// A second store derives the key from the file's salt on its own
+	other, _ := jankdb.NewStore[historyConfig](jankdb.OSFileSystem{}, dir, opts)
+	_ = other.Load()
+	other.Set(historyConfig{Theme: "blue"})
+	if err := other.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	raw, _ := os.ReadFile(filepath.Join(dir, "config.json.history"))
+	lines := strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[0], `"salt"`) {
+		t.Fatalf("expected a header and 3 entries, got %q", raw)
+	}
+	if strings.Count(string(raw), "salt") != 1 {
+		t.Error("expected one salt for the whole file")
+	}
+
+	var themes []string
+	for rec, err := range s.History() {
+		if err != nil {
+			t.Fatalf("History failed: %v", err)
+		}
+		themes = append(themes, rec.Value.Theme)
+	}
+	if strings.Join(themes, ",") != "light,dark,blue" {
+		t.Errorf("unexpected history %v", themes)
+	}
+
+	opts.EncryptionKey = "wrong"
+	wrong, _ := jankdb.NewStore[historyConfig](jankdb.OSFileSystem{}, dir, opts)
+	for _, err := range wrong.History() {
+		if err == nil {
+			t.Error("expected the wrong key to fail")
+		}
+		break
+	}
+}
+
+func TestStore_History_Locking(t *testing.T) {
+	s, _ := jankdb.NewStore[int](jankdb.OSFileSystem{}, t.TempDir(), jankdb.StoreOptions{
+		FileName:      "n.json",
+		EncryptionKey: "secret",
+		History:       &jankdb.HistoryOptions{},
+	})
+	for i := range 3 {
+		s.Set(i)
+		if err := s.Save(); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	// The loop may use the store, and readers may run alongside saves
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 5 {
+			_, _ = s.At(time.Now())
+			s.Set(10 + i)
+			_ = s.Save()
+		}
+	}()
+	n := 0
+	for rec, err := range s.History() {
+		if err != nil {
+			t.Fatalf("History failed: %v", err)
+		}
+		s.Set(rec.Value)
+		n++
+	}
+	<-done
+	if n < 3 {
+		t.Errorf("expected at least 3 versions, got %d", n)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := s.At(time.Now()); !errors.Is(err, jankdb.ErrClosed) {
+		t.Errorf("expected ErrClosed from At, got %v", err)
+	}
+	for _, err := range s.History() {
+		if !errors.Is(err, jankdb.ErrClosed) {
+			t.Errorf("expected ErrClosed from History, got %v", err)
+		}
+	}
+}
+
+func TestStore_History_WithoutOpenFile(t *testing.T) {
+	// Reading only needs an io.ReadCloser; OpenFile is left unset
+	fs, files := newMemFS()
+	fs.OpenFunc = func(path string) (io.ReadCloser, error) {
+		data, err := fs.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	s, _ := jankdb.NewStore[int](fs, "/base", jankdb.StoreOptions{
+		FileName: "n.json",
+		History:  &jankdb.HistoryOptions{},
+	})
+	for i := range 3 {
+		s.Set(i)
+		if err := s.Save(); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	if got := strings.Count(string(files["/base/n.json.history"]), "\n"); got != 3 {
+		t.Errorf("expected 3 history lines, got %d", got)
+	}
+	if v, err := s.At(time.Now()); err != nil || v != 2 {
+		t.Errorf("expected 2, got %d, %v", v, err)
+	}
+}
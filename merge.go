@@ -0,0 +1,186 @@
+package jankdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrMergeConflict is returned by MergeJSON when both sides changed the same
+// value in different ways.
+var ErrMergeConflict = errors.New("merge conflict")
+
+// ConflictResolver combines our in-memory value with the one another writer
+// saved since our last Load or Save. base is the value we last loaded or
+// saved (the zero T if there was none).
+type ConflictResolver[T any] func(base, ours, theirs T) (T, error)
+
+// SetConflictResolver makes Save check whether the file changed on disk since
+// the last Load or Save and, if so, save fn(base, ours, theirs) instead of
+// clobbering the other writer. Set it before Load so the base is known.
+// Pass nil to go back to last-writer-wins.
+func (s *Store[T]) SetConflictResolver(fn ConflictResolver[T]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resolver = fn
+}
+
+// resolveConflict is called by save (with s.mu held) when a resolver is set.
+// If the file changed under us, it merges and replaces s.data.
+func (s *Store[T]) resolveConflict(fs FileSystem) error {
+	disk, err := s.readFile(fs)
+	if err != nil {
+		return err
+	}
+	current := disk.version()
+	if current.Hash == s.version.Hash {
+		return nil
+	}
+
+	var theirs T
+	if disk.exists {
+		if err := json.Unmarshal(disk.payload, &theirs); err != nil {
+			return fmt.Errorf("failed to unmarshal their data: %w", err)
+		}
+	}
+	var base T
+	if s.base != nil {
+		if err := json.Unmarshal(s.base, &base); err != nil {
+			return fmt.Errorf("failed to unmarshal merge base: %w", err)
+		}
+	}
+
+	merged, err := s.resolver(base, s.data, theirs)
+	if err != nil {
+		return fmt.Errorf("failed to resolve conflicting save: %w", err)
+	}
+	s.data = merged
+	if s.cache != nil {
+		s.cache.Set("all", s.data)
+	}
+	s.version.Revision = max(s.version.Revision, current.Revision)
+	return nil
+}
+
+// rememberBase keeps a copy of what is on disk for the next merge.
+func (s *Store[T]) rememberBase(payload []byte) {
+	if s.resolver == nil {
+		s.base = nil
+		return
+	}
+	s.base = append([]byte(nil), payload...)
+}
+
+// MergeJSON is a ConflictResolver for JSON object documents. Object fields
+// (struct fields, map keys) are merged one by one: a field changed on only
+// one side takes that side's value, fields changed the same way on both
+// sides are kept, and nested objects are merged recursively. Anything else
+// changed differently on both sides is an ErrMergeConflict.
+func MergeJSON[T any](base, ours, theirs T) (T, error) {
+	var zero T
+	b, err := toJSONValue(base)
+	if err != nil {
+		return zero, err
+	}
+	o, err := toJSONValue(ours)
+	if err != nil {
+		return zero, err
+	}
+	t, err := toJSONValue(theirs)
+	if err != nil {
+		return zero, err
+	}
+
+	merged, err := mergeValues("", b, o, t)
+	if err != nil {
+		return zero, err
+	}
+
+	out, err := json.Marshal(merged)
+	if err != nil {
+		return zero, fmt.Errorf("failed to marshal merged data: %w", err)
+	}
+	var result T
+	if err := json.Unmarshal(out, &result); err != nil {
+		return zero, fmt.Errorf("failed to unmarshal merged data: %w", err)
+	}
+	return result, nil
+}
+
+// missing marks an object key that is absent on one side of a merge.
+type missingValue struct{}
+
+var missing = missingValue{}
+
+func mergeValues(path string, base, ours, theirs any) (any, error) {
+	switch {
+	case reflect.DeepEqual(ours, theirs):
+		return ours, nil
+	case reflect.DeepEqual(base, ours):
+		return theirs, nil
+	case reflect.DeepEqual(base, theirs):
+		return ours, nil
+	}
+
+	oursObj, ok1 := ours.(map[string]any)
+	theirsObj, ok2 := theirs.(map[string]any)
+	if !ok1 || !ok2 {
+		if path == "" {
+			path = "/"
+		}
+		return nil, fmt.Errorf("%w at %s", ErrMergeConflict, path)
+	}
+	baseObj, _ := base.(map[string]any) // a missing or non-object base merges like an empty one
+
+	merged := make(map[string]any)
+	keys := make(map[string]struct{})
+	for _, obj := range []map[string]any{baseObj, oursObj, theirsObj} {
+		for k := range obj {
+			keys[k] = struct{}{}
+		}
+	}
+	for k := range keys {
+		v, err := mergeValues(path+"/"+escapePointerToken(k), lookup(baseObj, k), lookup(oursObj, k), lookup(theirsObj, k))
+		if err != nil {
+			return nil, err
+		}
+		if v != missing {
+			merged[k] = v
+		}
+	}
+	return merged, nil
+}
+
+func lookup(obj map[string]any, key string) any {
+	if v, ok := obj[key]; ok {
+		return v
+	}
+	return missing
+}
+
+// toJSONValue converts v to its generic JSON form (maps, slices, json.Number, ...).
+func toJSONValue(v any) (any, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal value: %w", err)
+	}
+	return decodeJSONValue(raw)
+}
+
+func decodeJSONValue(raw []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var out any
+	if err := dec.Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	return out, nil
+}
+
+// escapePointerToken escapes a key for use in a JSON pointer (RFC 6901).
+func escapePointerToken(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
@@ -0,0 +1,101 @@
+package jankdb_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/guarzo/jankdb"
+)
+
+type settings struct {
+	Theme    string            `json:"theme"`
+	Volume   int               `json:"volume"`
+	Keybinds map[string]string `json:"keybinds"`
+}
+
+func TestMergeJSON(t *testing.T) {
+	base := settings{Theme: "dark", Volume: 5, Keybinds: map[string]string{"q": "quit"}}
+	ours := settings{Theme: "light", Volume: 5, Keybinds: map[string]string{"q": "quit", "s": "save"}}
+	theirs := settings{Theme: "dark", Volume: 9, Keybinds: map[string]string{"h": "help"}}
+
+	merged, err := jankdb.MergeJSON(base, ours, theirs)
+	if err != nil {
+		t.Fatalf("MergeJSON failed: %v", err)
+	}
+	if merged.Theme != "light" || merged.Volume != 9 {
+		t.Errorf("expected theme from ours and volume from theirs, got %+v", merged)
+	}
+	want := map[string]string{"s": "save", "h": "help"} // "q" was deleted by them
+	if len(merged.Keybinds) != len(want) || merged.Keybinds["s"] != "save" || merged.Keybinds["h"] != "help" {
+		t.Errorf("expected keybinds %v, got %v", want, merged.Keybinds)
+	}
+}
+
+func TestMergeJSON_Conflict(t *testing.T) {
+	base := settings{Volume: 5}
+	_, err := jankdb.MergeJSON(base, settings{Volume: 6}, settings{Volume: 7})
+	if !errors.Is(err, jankdb.ErrMergeConflict) {
+		t.Errorf("expected ErrMergeConflict, got %v", err)
+	}
+}
+
+func TestStore_Save_MergesOnConflict(t *testing.T) {
+	mockFS, _ := newMemFS()
+	opts := jankdb.StoreOptions{FileName: "s.json", MergeOnConflict: true}
+
+	seed, _ := jankdb.NewStore[settings](mockFS, "/base", opts)
+	seed.Set(settings{Theme: "dark", Volume: 5})
+	_ = seed.Save()
+
+	a, _ := jankdb.NewStore[settings](mockFS, "/base", opts)
+	b, _ := jankdb.NewStore[settings](mockFS, "/base", opts)
+	_ = a.Load()
+	_ = b.Load()
+
+	va := a.Get()
+	va.Theme = "light"
+	a.Set(va)
+	if err := a.Save(); err != nil {
+		t.Fatalf("a.Save failed: %v", err)
+	}
+
+	vb := b.Get()
+	vb.Volume = 9
+	b.Set(vb)
+	if err := b.Save(); err != nil {
+		t.Fatalf("b.Save failed: %v", err)
+	}
+
+	check, _ := jankdb.NewStore[settings](mockFS, "/base", jankdb.StoreOptions{FileName: "s.json"})
+	_ = check.Load()
+	if got := check.Get(); got.Theme != "light" || got.Volume != 9 {
+		t.Errorf("expected both changes to survive, got %+v", got)
+	}
+	if got := b.Get(); got.Theme != "light" {
+		t.Errorf("expected b's in-memory value to be the merged one, got %+v", got)
+	}
+}
+
+func TestStore_SetConflictResolver(t *testing.T) {
+	mockFS, _ := newMemFS()
+	opts := jankdb.StoreOptions{FileName: "n.json"}
+
+	a, _ := jankdb.NewStore[int](mockFS, "/base", opts)
+	b, _ := jankdb.NewStore[int](mockFS, "/base", opts)
+	// Counters: apply both sides' increments
+	b.SetConflictResolver(func(base, ours, theirs int) (int, error) {
+		return theirs + (ours - base), nil
+	})
+	_ = a.Load()
+	_ = b.Load()
+
+	a.Set(a.Get() + 2)
+	_ = a.Save()
+	b.Set(b.Get() + 3)
+	if err := b.Save(); err != nil {
+		t.Fatalf("b.Save failed: %v", err)
+	}
+	if b.Get() != 5 {
+		t.Errorf("expected merged counter 5, got %d", b.Get())
+	}
+}
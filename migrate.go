@@ -0,0 +1,64 @@
+package jankdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrMissingMigration is returned by Load when a file's schema version
+// has no registered migration leading towards the store's SchemaVersion.
+var ErrMissingMigration = errors.New("missing schema migration")
+
+// MigrationFunc upgrades the raw JSON of a stored value from one schema version to another.
+type MigrationFunc func(json.RawMessage) (json.RawMessage, error)
+
+type migration struct {
+	to int
+	fn MigrationFunc
+}
+
+// RegisterMigration registers fn to upgrade data at schema version `from` to version `to`.
+// Files without an envelope are treated as version 0.
+func (s *Store[T]) RegisterMigration(from, to int, fn MigrationFunc) error {
+	if from < 0 || to <= from {
+		return fmt.Errorf("invalid migration %d -> %d", from, to)
+	}
+	if fn == nil {
+		return fmt.Errorf("migration %d -> %d has no function", from, to)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.migrations[from]; ok {
+		return fmt.Errorf("migration from version %d already registered (to %d)", from, existing.to)
+	}
+	s.migrations[from] = migration{to: to, fn: fn}
+	return nil
+}
+
+// migrate runs registered migrations on payload from `version` up to s.schemaVersion.
+func (s *Store[T]) migrate(payload json.RawMessage, version int) (json.RawMessage, error) {
+	if version > s.schemaVersion {
+		return nil, fmt.Errorf("file schema version %d is newer than supported version %d", version, s.schemaVersion)
+	}
+
+	for version < s.schemaVersion {
+		m, ok := s.migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("%w: from version %d", ErrMissingMigration, version)
+		}
+		if m.to > s.schemaVersion {
+			return nil, fmt.Errorf("migration %d -> %d overshoots schema version %d", version, m.to, s.schemaVersion)
+		}
+
+		out, err := m.fn(payload)
+		if err != nil {
+			return nil, fmt.Errorf("migration %d -> %d failed: %w", version, m.to, err)
+		}
+		payload = out
+		version = m.to
+	}
+	return payload, nil
+}
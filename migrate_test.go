@@ -0,0 +1,75 @@
+package jankdb_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/guarzo/jankdb"
+)
+
+type userV2 struct {
+	FullName string `json:"full_name"`
+}
+
+func TestStore_Migration(t *testing.T) {
+	mockFS, files := newMemFS()
+	files["/base/users.json"] = []byte(`{"name": "alice"}`)
+
+	s, _ := jankdb.NewStore[userV2](mockFS, "/base", jankdb.StoreOptions{
+		FileName:      "users.json",
+		SchemaVersion: 1,
+	})
+	err := s.RegisterMigration(0, 1, func(raw json.RawMessage) (json.RawMessage, error) {
+		var old struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(raw, &old); err != nil {
+			return nil, err
+		}
+		return json.Marshal(userV2{FullName: old.Name})
+	})
+	if err != nil {
+		t.Fatalf("RegisterMigration failed: %v", err)
+	}
+
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := s.Get().FullName; got != "alice" {
+		t.Errorf("expected migrated name 'alice', got %q", got)
+	}
+	if string(files["/base/users.json.v0.bak"]) != `{"name": "alice"}` {
+		t.Errorf("expected pre-migration backup, got %q", files["/base/users.json.v0.bak"])
+	}
+
+	// The upgraded file should load without needing the migration again
+	s2, _ := jankdb.NewStore[userV2](mockFS, "/base", jankdb.StoreOptions{
+		FileName:      "users.json",
+		SchemaVersion: 1,
+	})
+	if err := s2.Load(); err != nil {
+		t.Fatalf("Load of migrated file failed: %v", err)
+	}
+	if got := s2.Get().FullName; got != "alice" {
+		t.Errorf("expected 'alice' after reload, got %q", got)
+	}
+}
+
+func TestStore_Migration_Missing(t *testing.T) {
+	mockFS, files := newMemFS()
+	files["/base/users.json"] = []byte(`{"name": "bob"}`)
+
+	s, _ := jankdb.NewStore[userV2](mockFS, "/base", jankdb.StoreOptions{
+		FileName:      "users.json",
+		SchemaVersion: 2,
+	})
+	_ = s.RegisterMigration(0, 1, func(raw json.RawMessage) (json.RawMessage, error) {
+		return raw, nil
+	})
+
+	err := s.Load()
+	if !errors.Is(err, jankdb.ErrMissingMigration) {
+		t.Errorf("expected ErrMissingMigration, got %v", err)
+	}
+}
@@ -0,0 +1,473 @@
+package jankdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+)
+
+// ErrInvalidPatch is returned for a patch document that can't be applied:
+// malformed JSON, unknown operations, or paths that don't exist.
+var ErrInvalidPatch = errors.New("invalid patch")
+
+// ErrPatchTestFailed is returned by ApplyPatch when a "test" operation doesn't match.
+var ErrPatchTestFailed = errors.New("patch test operation failed")
+
+// patchOp is one operation of an RFC 6902 JSON Patch.
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ApplyPatch applies an RFC 6902 JSON Patch to the JSON form of the data and
+// saves the result, all under the store's lock like Update. The patch is
+// all-or-nothing: if any operation fails, or the result doesn't unmarshal
+// into T (including fields T doesn't have), nothing changes.
+func (s *Store[T]) ApplyPatch(patch []byte) error {
+	return s.ApplyPatchContext(context.Background(), patch)
+}
+
+// ApplyPatchContext is ApplyPatch with a context, see UpdateContext.
+func (s *Store[T]) ApplyPatchContext(ctx context.Context, patch []byte) error {
+	var ops []patchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return s.UpdateContext(ctx, func(cur T) (T, error) {
+		return patchValue(cur, func(doc any) (any, error) {
+			return applyPatch(doc, ops)
+		})
+	})
+}
+
+// ApplyMergePatch applies an RFC 7396 JSON Merge Patch to the JSON form of
+// the data and saves the result, with the same guarantees as ApplyPatch.
+func (s *Store[T]) ApplyMergePatch(patch []byte) error {
+	return s.ApplyMergePatchContext(context.Background(), patch)
+}
+
+// ApplyMergePatchContext is ApplyMergePatch with a context, see UpdateContext.
+func (s *Store[T]) ApplyMergePatchContext(ctx context.Context, patch []byte) error {
+	p, err := decodeJSONValue(patch)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return s.UpdateContext(ctx, func(cur T) (T, error) {
+		return patchValue(cur, func(doc any) (any, error) {
+			return mergePatch(doc, p), nil
+		})
+	})
+}
+
+// patchValue runs fn on the JSON form of v and decodes the result into a new
+// T, rejecting fields T doesn't have rather than silently dropping them.
+func patchValue[T any](v T, fn func(doc any) (any, error)) (T, error) {
+	var zero T
+	doc, err := toJSONValue(v)
+	if err != nil {
+		return zero, err
+	}
+	doc, err = fn(doc)
+	if err != nil {
+		return zero, err
+	}
+
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return zero, fmt.Errorf("failed to marshal patched data: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var out T
+	if err := dec.Decode(&out); err != nil {
+		return zero, fmt.Errorf("%w: result doesn't fit the stored type: %v", ErrInvalidPatch, err)
+	}
+	return out, nil
+}
+
+// applyPatch applies ops to doc in order. doc may be modified in place.
+func applyPatch(doc any, ops []patchOp) (any, error) {
+	for i, op := range ops {
+		var err error
+		doc, err = applyOp(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("patch operation %d (%s %q): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+func applyOp(doc any, op patchOp) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	value := func() (any, error) {
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: missing value", ErrInvalidPatch)
+		}
+		return decodeJSONValue(op.Value)
+	}
+	from := func() ([]string, error) {
+		p, err := parsePointer(op.From)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+		return p, nil
+	}
+
+	switch op.Op {
+	case "add":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, v)
+	case "remove":
+		doc, _, err := removeValue(doc, path)
+		return doc, err
+	case "replace":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return v, nil
+		}
+		if doc, _, err = removeValue(doc, path); err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, v)
+	case "move":
+		src, err := from()
+		if err != nil {
+			return nil, err
+		}
+		if len(path) > len(src) && slices.Equal(path[:len(src)], src) {
+			return nil, fmt.Errorf("%w: can't move a value into itself", ErrInvalidPatch)
+		}
+		doc, v, err := removeValue(doc, src)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, v)
+	case "copy":
+		src, err := from()
+		if err != nil {
+			return nil, err
+		}
+		v, err := getValue(doc, src)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, copyJSONValue(v))
+	case "test":
+		want, err := value()
+		if err != nil {
+			return nil, err
+		}
+		got, err := getValue(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(got, want) {
+			return nil, ErrPatchTestFailed
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidPatch, op.Op)
+	}
+}
+
+// getValue returns the value at path.
+func getValue(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch c := doc.(type) {
+		case map[string]any:
+			v, ok := c[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidPatch, ErrPathNotFound)
+			}
+			doc = v
+		case []any:
+			i, ok := arrayIndex(token, len(c))
+			if !ok {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidPatch, ErrPathNotFound)
+			}
+			doc = c[i]
+		default:
+			return nil, fmt.Errorf("%w: %w", ErrInvalidPatch, ErrPathNotFound)
+		}
+	}
+	return doc, nil
+}
+
+// updateParent calls fn with the container holding the last token of path
+// and stores the container fn returns in its place. path must not be empty.
+func updateParent(doc any, path []string, fn func(parent any, key string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	child, err := getValue(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err = updateParent(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+	switch c := doc.(type) {
+	case map[string]any:
+		c[path[0]] = child
+	case []any:
+		i, _ := arrayIndex(path[0], len(c))
+		c[i] = child
+	}
+	return doc, nil
+}
+
+// addValue implements "add": set an object member, or insert into an array
+// ("-" appends). An empty path replaces the whole document.
+func addValue(doc any, path []string, v any) (any, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+	return updateParent(doc, path, func(parent any, key string) (any, error) {
+		switch c := parent.(type) {
+		case map[string]any:
+			c[key] = v
+			return c, nil
+		case []any:
+			if key == "-" {
+				return append(c, v), nil
+			}
+			i, ok := arrayIndex(key, len(c)+1)
+			if !ok {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidPatch, ErrPathNotFound)
+			}
+			return slices.Insert(c, i, v), nil
+		default:
+			return nil, fmt.Errorf("%w: %w", ErrInvalidPatch, ErrPathNotFound)
+		}
+	})
+}
+
+// removeValue implements "remove", also returning the removed value.
+func removeValue(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("%w: can't remove the whole document", ErrInvalidPatch)
+	}
+	var removed any
+	doc, err := updateParent(doc, path, func(parent any, key string) (any, error) {
+		switch c := parent.(type) {
+		case map[string]any:
+			v, ok := c[key]
+			if !ok {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidPatch, ErrPathNotFound)
+			}
+			removed = v
+			delete(c, key)
+			return c, nil
+		case []any:
+			i, ok := arrayIndex(key, len(c))
+			if !ok {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidPatch, ErrPathNotFound)
+			}
+			removed = c[i]
+			return slices.Delete(c, i, i+1), nil
+		default:
+			return nil, fmt.Errorf("%w: %w", ErrInvalidPatch, ErrPathNotFound)
+		}
+	})
+	return doc, removed, err
+}
+
+// mergePatch applies an RFC 7396 merge patch to target.
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any)
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+// copyJSONValue deep-copies a generic JSON value.
+func copyJSONValue(v any) any {
+	switch c := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(c))
+		for k, e := range c {
+			out[k] = copyJSONValue(e)
+		}
+		return out
+	case []any:
+		out := make([]any, len(c))
+		for i, e := range c {
+			out[i] = copyJSONValue(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// jsonEqual compares generic JSON values, treating numbers by value (1 == 1.0).
+func jsonEqual(a, b any) bool {
+	switch x := a.(type) {
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, ok := y[k]
+			if !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if x == y {
+			return true
+		}
+		xr, ok1 := new(big.Rat).SetString(string(x))
+		yr, ok2 := new(big.Rat).SetString(string(y))
+		return ok1 && ok2 && xr.Cmp(yr) == 0
+	default:
+		return a == b
+	}
+}
+
+// Diff returns an RFC 6902 JSON Patch that turns the JSON form of old into
+// that of new, so ApplyPatch(Diff(old, new)) on a store holding old leaves it
+// holding new. Object members are added, removed or diffed recursively;
+// arrays are diffed element by element, then shortened or extended at the end.
+func Diff[T any](old, new T) ([]byte, error) {
+	a, err := toJSONValue(old)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toJSONValue(new)
+	if err != nil {
+		return nil, err
+	}
+	return diffJSONValues(a, b)
+}
+
+// diffJSONValues is Diff for generic JSON values.
+func diffJSONValues(a, b any) ([]byte, error) {
+	ops := []patchOp{}
+	if err := diffValues("", a, b, &ops); err != nil {
+		return nil, err
+	}
+	out, err := json.Marshal(ops)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal patch: %w", err)
+	}
+	return out, nil
+}
+
+func diffValues(path string, a, b any, ops *[]patchOp) error {
+	if jsonEqual(a, b) {
+		return nil
+	}
+
+	switch x := a.(type) {
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(x)+len(y))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		for k := range y {
+			if _, ok := x[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			p := path + "/" + escapePointerToken(k)
+			av, inA := x[k]
+			bv, inB := y[k]
+			switch {
+			case !inB:
+				*ops = append(*ops, patchOp{Op: "remove", Path: p})
+			case !inA:
+				if err := addOp(ops, "add", p, bv); err != nil {
+					return err
+				}
+			default:
+				if err := diffValues(p, av, bv, ops); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case []any:
+		y, ok := b.([]any)
+		if !ok {
+			break
+		}
+		common := min(len(x), len(y))
+		for i := range common {
+			if err := diffValues(fmt.Sprintf("%s/%d", path, i), x[i], y[i], ops); err != nil {
+				return err
+			}
+		}
+		// Remove from the end so earlier indexes stay valid
+		for i := len(x) - 1; i >= common; i-- {
+			*ops = append(*ops, patchOp{Op: "remove", Path: fmt.Sprintf("%s/%d", path, i)})
+		}
+		for i := common; i < len(y); i++ {
+			if err := addOp(ops, "add", fmt.Sprintf("%s/%d", path, i), y[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return addOp(ops, "replace", path, b)
+}
+
+func addOp(ops *[]patchOp, op, path string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal patch value: %w", err)
+	}
+	*ops = append(*ops, patchOp{Op: op, Path: path, Value: raw})
+	return nil
+}
@@ -0,0 +1,140 @@
+package jankdb_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/guarzo/jankdb"
+)
+
+type patchConfig struct {
+	Name    string            `json:"name"`
+	Port    int               `json:"port"`
+	Tags    []string          `json:"tags"`
+	Limits  map[string]int    `json:"limits"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+func newPatchStore(t *testing.T) (*jankdb.Store[patchConfig], map[string][]byte) {
+	t.Helper()
+	mockFS, files := newMemFS()
+	s, _ := jankdb.NewStore[patchConfig](mockFS, "/base", jankdb.StoreOptions{FileName: "config.json"})
+	s.Set(patchConfig{Name: "api", Port: 80, Tags: []string{"a", "b"}, Limits: map[string]int{"rps": 10}})
+	return s, files
+}
+
+func TestStore_ApplyPatch(t *testing.T) {
+	s, files := newPatchStore(t)
+	patch := `[
+		{"op": "test", "path": "/port", "value": 80.0},
+		{"op": "replace", "path": "/port", "value": 8080},
+		{"op": "add", "path": "/tags/1", "value": "x"},
+		{"op": "add", "path": "/tags/-", "value": "z"},
+		{"op": "remove", "path": "/tags/0"},
+		{"op": "copy", "from": "/limits", "path": "/headers"},
+		{"op": "remove", "path": "/headers/rps"},
+		{"op": "add", "path": "/headers/a~1b", "value": "v"},
+		{"op": "move", "from": "/name", "path": "/headers/name"},
+		{"op": "add", "path": "/name", "value": "web"}
+	]`
+	if err := s.ApplyPatch([]byte(patch)); err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+	want := patchConfig{
+		Name:    "web",
+		Port:    8080,
+		Tags:    []string{"x", "b", "z"},
+		Limits:  map[string]int{"rps": 10},
+		Headers: map[string]string{"a/b": "v", "name": "api"},
+	}
+	if got := s.Get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if _, ok := files["/base/config.json"]; !ok {
+		t.Error("expected ApplyPatch to save")
+	}
+}
+
+func TestStore_ApplyPatch_AllOrNothing(t *testing.T) {
+	for name, patch := range map[string]string{
+		"failed test":    `[{"op": "replace", "path": "/port", "value": 1}, {"op": "test", "path": "/name", "value": "nope"}]`,
+		"missing path":   `[{"op": "replace", "path": "/port", "value": 1}, {"op": "remove", "path": "/limits/nope"}]`,
+		"unknown field":  `[{"op": "add", "path": "/typo", "value": 1}]`,
+		"wrong type":     `[{"op": "replace", "path": "/port", "value": "eighty"}]`,
+		"unknown op":     `[{"op": "frobnicate", "path": "/port"}]`,
+		"missing value":  `[{"op": "add", "path": "/port"}]`,
+		"move into self": `[{"op": "move", "from": "/limits", "path": "/limits/inner"}]`,
+		"not a patch":    `{"op": "add"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			s, files := newPatchStore(t)
+			before := s.Get()
+			err := s.ApplyPatch([]byte(patch))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !errors.Is(err, jankdb.ErrInvalidPatch) && !errors.Is(err, jankdb.ErrPatchTestFailed) {
+				t.Errorf("expected ErrInvalidPatch or ErrPatchTestFailed, got %v", err)
+			}
+			if !reflect.DeepEqual(s.Get(), before) {
+				t.Errorf("data changed by failed patch: %+v", s.Get())
+			}
+			if len(files) != 0 {
+				t.Error("expected nothing saved")
+			}
+		})
+	}
+}
+
+func TestStore_ApplyMergePatch(t *testing.T) {
+	s, _ := newPatchStore(t)
+	patch := `{"port": 443, "tags": ["only"], "limits": {"rps": null, "burst": 5}, "headers": {"x": "y"}}`
+	if err := s.ApplyMergePatch([]byte(patch)); err != nil {
+		t.Fatalf("ApplyMergePatch failed: %v", err)
+	}
+	want := patchConfig{
+		Name:    "api",
+		Port:    443,
+		Tags:    []string{"only"},
+		Limits:  map[string]int{"burst": 5},
+		Headers: map[string]string{"x": "y"},
+	}
+	if got := s.Get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if err := s.ApplyMergePatch([]byte(`{"nope": 1}`)); !errors.Is(err, jankdb.ErrInvalidPatch) {
+		t.Errorf("expected ErrInvalidPatch for unknown field, got %v", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := patchConfig{Name: "api", Port: 80, Tags: []string{"a", "b", "c"}, Limits: map[string]int{"rps": 10, "gone": 1}}
+	for name, updated := range map[string]patchConfig{
+		"same":    old,
+		"changed": {Name: "web", Port: 80, Tags: []string{"a", "x"}, Limits: map[string]int{"rps": 20, "new": 2}, Headers: map[string]string{"h": "v"}},
+		"grown":   {Name: "api", Port: 80, Tags: []string{"a", "b", "c", "d", "e"}, Limits: map[string]int{}},
+		"emptied": {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			patch, err := jankdb.Diff(old, updated)
+			if err != nil {
+				t.Fatalf("Diff failed: %v", err)
+			}
+			if name == "same" && string(patch) != "[]" {
+				t.Errorf("expected empty patch, got %s", patch)
+			}
+
+			mockFS, _ := newMemFS()
+			s, _ := jankdb.NewStore[patchConfig](mockFS, "/base", jankdb.StoreOptions{FileName: "config.json"})
+			s.Set(old)
+			if err := s.ApplyPatch(patch); err != nil {
+				t.Fatalf("ApplyPatch(%s) failed: %v", patch, err)
+			}
+			if got := s.Get(); !reflect.DeepEqual(got, updated) {
+				t.Errorf("patch %s gave %+v, want %+v", patch, got, updated)
+			}
+		})
+	}
+}
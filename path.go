@@ -0,0 +1,99 @@
+package jankdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrInvalidPath is returned by NewStore when SubDir or FileName would
+	// place the store's file outside its base path.
+	ErrInvalidPath = errors.New("invalid store path")
+
+	// ErrSymlink is returned when NoFollowSymlinks is set and the store's
+	// file or one of its directories under the base path is a symlink.
+	ErrSymlink = errors.New("refusing to follow symlink")
+)
+
+// SymlinkFileSystem is implemented by file systems that can stat a path
+// without following symlinks. It is required for NoFollowSymlinks.
+type SymlinkFileSystem interface {
+	Lstat(path string) (os.FileInfo, error)
+}
+
+// validatePathOptions checks SubDir and FileName, which often come from user
+// input, before they are joined onto the base path.
+func validatePathOptions(fs FileSystem, opts StoreOptions) error {
+	if opts.FileName == "" {
+		return fmt.Errorf("%w: FileName is required", ErrInvalidPath)
+	}
+	if err := validateRelPath("FileName", opts.FileName); err != nil {
+		return err
+	}
+	if opts.SubDir != "" {
+		if err := validateRelPath("SubDir", opts.SubDir); err != nil {
+			return err
+		}
+	}
+	if opts.NoFollowSymlinks {
+		if _, ok := fs.(SymlinkFileSystem); !ok {
+			return fmt.Errorf("NoFollowSymlinks needs a file system that implements SymlinkFileSystem")
+		}
+	}
+	if opts.Durable {
+		if _, ok := fs.(Syncer); !ok {
+			return fmt.Errorf("Durable needs a file system that implements Syncer")
+		}
+	}
+	return nil
+}
+
+func validateRelPath(field, p string) error {
+	if filepath.IsAbs(p) || strings.HasPrefix(p, "/") || strings.HasPrefix(p, `\`) {
+		return fmt.Errorf("%w: %s %q must be relative", ErrInvalidPath, field, p)
+	}
+	for _, part := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return fmt.Errorf("%w: %s %q must not contain '..'", ErrInvalidPath, field, p)
+		}
+	}
+	if !filepath.IsLocal(p) {
+		return fmt.Errorf("%w: %s %q is not a local path", ErrInvalidPath, field, p)
+	}
+	return nil
+}
+
+// checkNoSymlinks walks from the base path down to the store's file and
+// fails if any existing component is a symlink. The base path itself is trusted.
+func (s *Store[T]) checkNoSymlinks(fs FileSystem) error {
+	if !s.noFollowSymlinks {
+		return nil
+	}
+	lfs, ok := fs.(SymlinkFileSystem)
+	if !ok {
+		return fmt.Errorf("file system can't check for symlinks")
+	}
+
+	rel, err := filepath.Rel(s.basePath, s.filePath())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPath, err)
+	}
+	current := s.basePath
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := lfs.Lstat(current)
+		if fs.IsNotExist(err) {
+			// Nothing further down exists yet
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to lstat %s: %w", current, err)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s", ErrSymlink, current)
+		}
+	}
+	return nil
+}
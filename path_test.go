@@ -0,0 +1,66 @@
+package jankdb_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/guarzo/jankdb"
+)
+
+func TestNewStore_InvalidPaths(t *testing.T) {
+	cases := []jankdb.StoreOptions{
+		{FileName: ""},
+		{FileName: "../../etc/foo"},
+		{FileName: "/etc/passwd"},
+		{FileName: "ok.json", SubDir: "../outside"},
+		{FileName: "ok.json", SubDir: "a/../../b"},
+		{FileName: "ok.json", SubDir: "/abs"},
+	}
+	for _, opts := range cases {
+		_, err := jankdb.NewStore[int](jankdb.OSFileSystem{}, "/base", opts)
+		if !errors.Is(err, jankdb.ErrInvalidPath) {
+			t.Errorf("SubDir=%q FileName=%q: expected ErrInvalidPath, got %v", opts.SubDir, opts.FileName, err)
+		}
+	}
+
+	if _, err := jankdb.NewStore[int](jankdb.OSFileSystem{}, "/base", jankdb.StoreOptions{
+		SubDir:   "app/loot",
+		FileName: "data.json",
+	}); err != nil {
+		t.Errorf("expected nested relative path to be accepted, got %v", err)
+	}
+}
+
+func TestStore_NoFollowSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+	base := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(base, "linked")); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := jankdb.NewStore[int](jankdb.OSFileSystem{}, base, jankdb.StoreOptions{
+		SubDir:           "linked",
+		FileName:         "n.json",
+		NoFollowSymlinks: true,
+	})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+
+	s.Set(1)
+	if err := s.Save(); !errors.Is(err, jankdb.ErrSymlink) {
+		t.Errorf("expected ErrSymlink from Save, got %v", err)
+	}
+	if err := s.Load(); !errors.Is(err, jankdb.ErrSymlink) {
+		t.Errorf("expected ErrSymlink from Load, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "n.json")); !os.IsNotExist(err) {
+		t.Error("expected nothing to be written through the symlink")
+	}
+}
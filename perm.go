@@ -0,0 +1,68 @@
+package jankdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// ErrInsecurePermissions is returned by Load when RequirePrivateFiles is set
+// and an encrypted store's files are readable by group or others.
+var ErrInsecurePermissions = errors.New("file is readable by group or others")
+
+// FileOwner is the uid/gid to give written files.
+type FileOwner struct {
+	UID int
+	GID int
+}
+
+// PermissionFileSystem is implemented by file systems that can change a
+// file's mode and owner. Without it, modes are whatever WriteFile produces
+// (subject to umask) and StoreOptions.Owner can't be honoured.
+type PermissionFileSystem interface {
+	Chmod(path string, mode os.FileMode) error
+	Chown(path string, uid, gid int) error
+}
+
+// checkPrivate enforces RequirePrivateFiles for encrypted stores.
+func (s *Store[T]) checkPrivate(fs FileSystem, info os.FileInfo) error {
+	if !s.requirePrivate || s.encryptionKey == "" || runtime.GOOS == "windows" {
+		return nil
+	}
+	if info != nil && info.Mode().Perm()&0044 != 0 {
+		return fmt.Errorf("%w: %s has mode %v", ErrInsecurePermissions, s.filePath(), info.Mode().Perm())
+	}
+	if s.keyFile != "" {
+		keyInfo, err := fs.Stat(s.keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to stat encryption key file: %w", err)
+		}
+		if keyInfo.Mode().Perm()&0044 != 0 {
+			return fmt.Errorf("%w: %s has mode %v", ErrInsecurePermissions, s.keyFile, keyInfo.Mode().Perm())
+		}
+	}
+	return nil
+}
+
+// applyPermissions sets mode and (optionally) owner on a freshly written file.
+func applyPermissions(fs FileSystem, path string, mode os.FileMode, owner *FileOwner) error {
+	pfs, ok := fs.(PermissionFileSystem)
+	if !ok {
+		if owner != nil {
+			return fmt.Errorf("file system can't change ownership of %s", path)
+		}
+		return nil
+	}
+
+	// WriteFile's mode is filtered through the umask; set it exactly.
+	if err := pfs.Chmod(path, mode); err != nil {
+		return fmt.Errorf("failed to chmod %s: %w", path, err)
+	}
+	if owner != nil {
+		if err := pfs.Chown(path, owner.UID, owner.GID); err != nil {
+			return fmt.Errorf("failed to chown %s: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package jankdb_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/guarzo/jankdb"
+)
+
+func TestStore_FileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix permissions only")
+	}
+	dir := t.TempDir()
+	fs := jankdb.OSFileSystem{}
+	s, _ := jankdb.NewStore[int](fs, dir, jankdb.StoreOptions{
+		SubDir:   "sub",
+		FileName: "n.json",
+		FileMode: 0640,
+		DirMode:  0700,
+	})
+	s.Set(1)
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	path := filepath.Join(dir, "sub", "n.json")
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("expected file mode 0640, got %v", info.Mode().Perm())
+	}
+	dirInfo, _ := os.Stat(filepath.Join(dir, "sub"))
+	if dirInfo.Mode().Perm() != 0700 {
+		t.Errorf("expected dir mode 0700, got %v", dirInfo.Mode().Perm())
+	}
+
+	// An existing file's mode survives the atomic replace
+	if err := os.Chmod(path, 0604); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	info, _ = os.Stat(path)
+	if info.Mode().Perm() != 0604 {
+		t.Errorf("expected preserved mode 0604, got %v", info.Mode().Perm())
+	}
+}
+
+func TestStore_RequirePrivateFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix permissions only")
+	}
+	dir := t.TempDir()
+	fs := jankdb.OSFileSystem{}
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte("pass123\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := jankdb.StoreOptions{
+		FileName:            "secret.json",
+		EncryptionKeyFile:   keyFile,
+		RequirePrivateFiles: true,
+	}
+	s, err := jankdb.NewStore[string](fs, dir, opts)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	s.Set("shh")
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := s.Load(); err != nil {
+		t.Fatalf("expected private files to load, got %v", err)
+	}
+
+	if err := os.Chmod(filepath.Join(dir, "secret.json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Load(); !errors.Is(err, jankdb.ErrInsecurePermissions) {
+		t.Errorf("expected ErrInsecurePermissions for data file, got %v", err)
+	}
+
+	if err := os.Chmod(filepath.Join(dir, "secret.json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(keyFile, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Load(); !errors.Is(err, jankdb.ErrInsecurePermissions) {
+		t.Errorf("expected ErrInsecurePermissions for key file, got %v", err)
+	}
+}
+
+func TestStore_Owner_Mock(t *testing.T) {
+	mockFS, _ := newMemFS()
+	var chowned []string
+	mockFS.ChownFunc = func(path string, uid, gid int) error {
+		if uid != 1000 || gid != 1001 {
+			t.Errorf("unexpected owner %d:%d", uid, gid)
+		}
+		chowned = append(chowned, path)
+		return nil
+	}
+
+	s, _ := jankdb.NewStore[int](mockFS, "/base", jankdb.StoreOptions{
+		FileName: "n.json",
+		Owner:    &jankdb.FileOwner{UID: 1000, GID: 1001},
+	})
+	s.Set(1)
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if len(chowned) != 1 {
+		t.Errorf("expected one chown, got %v", chowned)
+	}
+}
@@ -0,0 +1,271 @@
+package jankdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrPathNotFound is returned by LoadPath when nothing in the file matches the pointer.
+var ErrPathNotFound = errors.New("path not found")
+
+// errNeedsFullRead makes LoadPath give up streaming, e.g. for files that
+// need a migration first.
+var errNeedsFullRead = errors.New("file needs a full read")
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped tokens.
+// "" is the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q: must start with '/'", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		if strings.Contains(strings.ReplaceAll(strings.ReplaceAll(t, "~0", ""), "~1", ""), "~") {
+			return nil, fmt.Errorf("invalid JSON pointer %q: bad escape in %q", pointer, t)
+		}
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses a pointer token as an index into an array of length n.
+func arrayIndex(token string, n int) (int, bool) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, false
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (n >= 0 && i >= n) {
+		return 0, false
+	}
+	return i, true
+}
+
+// LoadPath reads a single value out of the store's file, addressed by a JSON
+// Pointer (RFC 6901) into T's JSON form, e.g. "/settings/theme". The file is
+// decoded as a token stream and only the requested subtree is kept, so
+// neither the rest of the document nor T is ever built in memory. In-memory
+// changes that haven't been saved aren't seen.
+//
+// Encrypted files are decrypted first (as a stream with CipherStream). Files
+// that need a migration are read whole and migrated.
+func (s *Store[T]) LoadPath(pointer string) (json.RawMessage, error) {
+	return s.LoadPathContext(context.Background(), pointer)
+}
+
+// LoadPathContext is LoadPath with a context, see LoadContext.
+func (s *Store[T]) LoadPathContext(ctx context.Context, pointer string) (json.RawMessage, error) {
+	path, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the file is read, so Get and other readers can carry on
+	if err := s.rlockContext(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	fs := fsWithContext(ctx, s.fs)
+	if err := s.checkNoSymlinks(fs); err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(s.filePath())
+	if fs.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s (no file)", ErrPathNotFound, pointer)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if err := s.checkPrivate(fs, info); err != nil {
+		return nil, err
+	}
+
+	if len(path) > 0 {
+		raw, err := s.streamPath(fs, path)
+		if !errors.Is(err, errNeedsFullRead) {
+			if errors.Is(err, ErrPathNotFound) {
+				return nil, fmt.Errorf("%w: %s", ErrPathNotFound, pointer)
+			}
+			return raw, err
+		}
+	}
+
+	disk, err := s.readFile(fs)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := descend(json.NewDecoder(bytes.NewReader(disk.payload)), path)
+	if errors.Is(err, ErrPathNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrPathNotFound, pointer)
+	}
+	return raw, err
+}
+
+// streamPath extracts path from the file without reading it whole.
+func (s *Store[T]) streamPath(fs FileSystem, path []string) (json.RawMessage, error) {
+	f, err := fs.Open(s.filePath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	pr, err := s.plaintextReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer pr.Close()
+
+	dec := json.NewDecoder(pr)
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	if tok != json.Delim('{') {
+		if s.schemaVersion > 0 {
+			return nil, errNeedsFullRead // no envelope: schema version 0
+		}
+		return walk(dec, tok, path)
+	}
+	if !dec.More() {
+		return nil, ErrPathNotFound
+	}
+	key, err := objectKey(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	if key != "jankdb" {
+		// A plain document, unless it's an envelope with its keys reordered
+		if s.schemaVersion > 0 || key == "data" {
+			return nil, errNeedsFullRead
+		}
+		return walkObject(dec, path, key)
+	}
+
+	var meta envelopeMeta
+	if err := dec.Decode(&meta); err != nil {
+		return nil, errNeedsFullRead
+	}
+	if s.schemaVersion > 0 && meta.Schema != s.schemaVersion {
+		return nil, errNeedsFullRead
+	}
+	for dec.More() {
+		if key, err = objectKey(dec); err != nil {
+			return nil, err
+		}
+		if key == "data" {
+			return descend(dec, path)
+		}
+		if err := skipValue(dec); err != nil {
+			return nil, err
+		}
+	}
+	return nil, errNeedsFullRead
+}
+
+// descend returns the value at path, starting at the value dec is about to read.
+func descend(dec *json.Decoder, path []string) (json.RawMessage, error) {
+	if len(path) == 0 {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("failed to decode JSON: %w", err)
+		}
+		return raw, nil
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	return walk(dec, tok, path)
+}
+
+// walk returns the value at path inside the value whose first token, tok,
+// has just been read. path must not be empty.
+func walk(dec *json.Decoder, tok json.Token, path []string) (json.RawMessage, error) {
+	switch tok {
+	case json.Delim('{'):
+		if !dec.More() {
+			return nil, ErrPathNotFound
+		}
+		key, err := objectKey(dec)
+		if err != nil {
+			return nil, err
+		}
+		return walkObject(dec, path, key)
+	case json.Delim('['):
+		i, ok := arrayIndex(path[0], -1)
+		if !ok {
+			return nil, ErrPathNotFound
+		}
+		for n := 0; dec.More(); n++ {
+			if n == i {
+				return descend(dec, path[1:])
+			}
+			if err := skipValue(dec); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, ErrPathNotFound
+}
+
+// walkObject continues walk inside an object whose key `key` has just been read.
+func walkObject(dec *json.Decoder, path []string, key string) (json.RawMessage, error) {
+	for {
+		if key == path[0] {
+			return descend(dec, path[1:])
+		}
+		if err := skipValue(dec); err != nil {
+			return nil, err
+		}
+		if !dec.More() {
+			return nil, ErrPathNotFound
+		}
+		var err error
+		if key, err = objectKey(dec); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func objectKey(dec *json.Decoder) (string, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return "", fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	key, ok := tok.(string)
+	if !ok {
+		return "", fmt.Errorf("failed to decode JSON: expected object key, got %v", tok)
+	}
+	return key, nil
+}
+
+// skipValue reads past the next value token by token, so skipped subtrees
+// are never held in memory.
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("failed to decode JSON: %w", err)
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package jankdb_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/guarzo/jankdb"
+)
+
+type pointerDoc struct {
+	Settings map[string]string `json:"settings"`
+	Items    []string          `json:"items"`
+	Big      map[string]int    `json:"big"`
+}
+
+func newPointerDoc() pointerDoc {
+	return pointerDoc{
+		Settings: map[string]string{"theme": "dark", "a/b": "slash", "m~n": "tilde"},
+		Items:    []string{"zero", "one", "two"},
+		Big:      benchItems(1000),
+	}
+}
+
+func TestStore_LoadPath(t *testing.T) {
+	cases := map[string]jankdb.StoreOptions{
+		"plain":      {},
+		"envelope":   {TrackRevisions: true, SchemaVersion: 2},
+		"base64":     {EncryptionKey: "pass"},
+		"stream":     {EncryptionKey: "pass", CipherFormat: jankdb.CipherStream, Compression: jankdb.GzipCompression},
+		"compressed": {Compression: jankdb.FlateCompression},
+	}
+	for name, opts := range cases {
+		t.Run(name, func(t *testing.T) {
+			opts.FileName = "doc.json"
+			s, _ := jankdb.NewStore[pointerDoc](jankdb.OSFileSystem{}, t.TempDir(), opts)
+			s.Set(newPointerDoc())
+			if err := s.Save(); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+
+			for pointer, want := range map[string]string{
+				"/settings/theme": `"dark"`,
+				"/settings/a~1b":  `"slash"`,
+				"/settings/m~0n":  `"tilde"`,
+				"/items/2":        `"two"`,
+				"/big/item-500":   `500`,
+			} {
+				got, err := s.LoadPath(pointer)
+				if err != nil {
+					t.Errorf("LoadPath(%q) failed: %v", pointer, err)
+				} else if string(got) != want {
+					t.Errorf("LoadPath(%q) = %s, want %s", pointer, got, want)
+				}
+			}
+
+			whole, err := s.LoadPath("")
+			if err != nil {
+				t.Fatalf("LoadPath(\"\") failed: %v", err)
+			}
+			var doc pointerDoc
+			if err := json.Unmarshal(whole, &doc); err != nil || doc.Settings["theme"] != "dark" {
+				t.Errorf("expected the whole document, got %v (%v)", doc, err)
+			}
+
+			for _, missing := range []string{"/settings/nope", "/items/3", "/items/01", "/items/x", "/settings/theme/deeper"} {
+				if _, err := s.LoadPath(missing); !errors.Is(err, jankdb.ErrPathNotFound) {
+					t.Errorf("LoadPath(%q): expected ErrPathNotFound, got %v", missing, err)
+				}
+			}
+		})
+	}
+}
+
+func TestStore_LoadPath_Errors(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := jankdb.NewStore[pointerDoc](jankdb.OSFileSystem{}, dir, jankdb.StoreOptions{FileName: "doc.json"})
+	if _, err := s.LoadPath("/settings"); !errors.Is(err, jankdb.ErrPathNotFound) {
+		t.Errorf("expected ErrPathNotFound without a file, got %v", err)
+	}
+	if _, err := s.LoadPath("settings"); err == nil {
+		t.Error("expected error for pointer without leading '/'")
+	}
+	if _, err := s.LoadPath("/a~2"); err == nil {
+		t.Error("expected error for bad escape")
+	}
+}
+
+func TestStore_LoadPath_Migrates(t *testing.T) {
+	dir := t.TempDir()
+	// A version 0 file (no envelope) with the old field name
+	if err := os.WriteFile(filepath.Join(dir, "doc.json"), []byte(`{"prefs":{"theme":"light"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := jankdb.NewStore[pointerDoc](jankdb.OSFileSystem{}, dir, jankdb.StoreOptions{FileName: "doc.json", SchemaVersion: 1})
+	_ = s.RegisterMigration(0, 1, func(in json.RawMessage) (json.RawMessage, error) {
+		var old struct {
+			Prefs map[string]string `json:"prefs"`
+		}
+		if err := json.Unmarshal(in, &old); err != nil {
+			return nil, err
+		}
+		return json.Marshal(pointerDoc{Settings: old.Prefs})
+	})
+
+	got, err := s.LoadPath("/settings/theme")
+	if err != nil {
+		t.Fatalf("LoadPath failed: %v", err)
+	}
+	if string(got) != `"light"` {
+		t.Errorf("expected migrated value, got %s", got)
+	}
+}
+
+// slowOpenFS blocks Open until release is closed.
+type slowOpenFS struct {
+	jankdb.OSFileSystem
+	opened  chan struct{}
+	release chan struct{}
+}
+
+func (f slowOpenFS) Open(path string) (io.ReadCloser, error) {
+	close(f.opened)
+	<-f.release
+	return f.OSFileSystem.Open(path)
+}
+
+func TestLoadPath_DoesNotBlockGet(t *testing.T) {
+	dir := t.TempDir()
+	fs := slowOpenFS{opened: make(chan struct{}), release: make(chan struct{})}
+	s, _ := jankdb.NewStore[pointerDoc](fs, dir, jankdb.StoreOptions{FileName: "doc.json"})
+	s.Set(newPointerDoc())
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := s.LoadPath("/settings")
+		done <- err
+	}()
+	<-fs.opened
+
+	got := make(chan struct{})
+	go func() {
+		s.Get()
+		close(got)
+	}()
+	select {
+	case <-got:
+	case <-time.After(5 * time.Second):
+		t.Error("Get blocked while LoadPath was reading")
+	}
+	close(fs.release)
+	if err := <-done; err != nil {
+		t.Fatalf("LoadPath failed: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package jankdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Rekey re-encrypts the store under newKey: the in-memory data is written
+// with it as Save would (so a store without a key becomes encrypted), and the
+// history, if any, is rewritten with it too. With an audit sink, it's
+// recorded as a "rekey" event.
+//
+// The EncryptionKeyFile, if any, isn't touched: put newKey there before the
+// next NewStore. Backups (.bak, Snapshot) keep the key they were written with.
+func (s *Store[T]) Rekey(newKey string) error {
+	return s.RekeyContext(context.Background(), newKey)
+}
+
+// RekeyContext is Rekey with a context, see SaveContext.
+func (s *Store[T]) RekeyContext(ctx context.Context, newKey string) error {
+	if newKey == "" {
+		return errors.New("new encryption key is empty")
+	}
+	if err := s.lockContext(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	fs := fsWithContext(ctx, s.fs)
+
+	// Read the history while we can still decrypt it
+	var history []historyEntry
+	if s.history != nil {
+		_, err := s.readHistory(fs, func(_ []byte, entry historyEntry) (bool, error) {
+			history = append(history, entry)
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Merge another writer's changes while the file can still be read with
+	// the old key; the write under newKey mustn't try again
+	if s.resolver != nil {
+		if err := s.resolveConflict(fs); err != nil {
+			return err
+		}
+	}
+
+	oldKey, oldAuditKey, oldSeal := s.encryptionKey, s.auditKey, s.historySeal
+	s.encryptionKey, s.auditKey, s.historySeal = newKey, nil, nil
+	revision, err := s.write(fs, false)
+	if err != nil {
+		s.encryptionKey, s.auditKey, s.historySeal = oldKey, oldAuditKey, oldSeal
+		return fmt.Errorf("failed to rekey: %w", err)
+	}
+
+	if len(history) > 0 {
+		seal, err := s.newHistorySeal()
+		if err != nil {
+			return fmt.Errorf("rekeyed, but failed to re-encrypt history: %w", err)
+		}
+		var out bytes.Buffer
+		out.Write(seal.header)
+		out.WriteByte('\n')
+		for _, entry := range history {
+			line, err := encodeHistoryEntry(seal, entry)
+			if err != nil {
+				return fmt.Errorf("rekeyed, but failed to re-encrypt history: %w", err)
+			}
+			out.Write(line)
+			out.WriteByte('\n')
+		}
+		opts := s.writeOptions()
+		opts.backup = false
+		if err := atomicWriteFile(fs, s.historyPath(), out.Bytes(), opts); err != nil {
+			return fmt.Errorf("rekeyed, but failed to re-encrypt history: %w", err)
+		}
+	}
+	if s.history != nil {
+		// Unsaved changes were just written too
+		if err := s.appendHistory(ctx, fs, revision); err != nil {
+			return fmt.Errorf("rekeyed, but failed to record history: %w", err)
+		}
+	}
+
+	if s.audit != nil {
+		newHash, err := s.contentHash(s.data)
+		if err != nil {
+			return fmt.Errorf("rekeyed, but failed to write audit record: %w", err)
+		}
+		oldHash := s.auditSaved
+		s.auditSaved, s.auditData = newHash, newHash
+		if err := s.recordAudit(ctx, AuditRekey, oldHash, newHash); err != nil {
+			return fmt.Errorf("rekeyed, but failed to write audit record: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package jankdb_test
+
+import (
+	"testing"
+
+	"github.com/guarzo/jankdb"
+)
+
+func TestStore_RekeyHistory(t *testing.T) {
+	dir := t.TempDir()
+	opts := jankdb.StoreOptions{
+		FileName:      "config.json",
+		EncryptionKey: "old passphrase",
+		History:       &jankdb.HistoryOptions{},
+	}
+	s, _ := jankdb.NewStore[historyConfig](jankdb.OSFileSystem{}, dir, opts)
+	s.Set(historyConfig{Theme: "light"})
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	// Unsaved changes are written (and recorded) by the rekey
+	s.Set(historyConfig{Theme: "dark"})
+	if err := s.Rekey("new passphrase"); err != nil {
+		t.Fatalf("Rekey failed: %v", err)
+	}
+	if s.IsDirty() {
+		t.Error("expected Rekey to save pending changes")
+	}
+	if err := s.Rekey(""); err == nil {
+		t.Error("expected an empty key to be refused")
+	}
+
+	opts.EncryptionKey = "new passphrase"
+	reopened, _ := jankdb.NewStore[historyConfig](jankdb.OSFileSystem{}, dir, opts)
+	if err := reopened.Load(); err != nil {
+		t.Fatalf("Load with new key failed: %v", err)
+	}
+	if reopened.Get().Theme != "dark" {
+		t.Errorf("unexpected data %+v", reopened.Get())
+	}
+	var themes []string
+	for rec, err := range reopened.History() {
+		if err != nil {
+			t.Fatalf("History with new key failed: %v", err)
+		}
+		themes = append(themes, rec.Value.Theme)
+	}
+	if len(themes) != 2 || themes[0] != "light" || themes[1] != "dark" {
+		t.Errorf("unexpected history %v", themes)
+	}
+}
+
+func TestStore_RekeyMergesConflicts(t *testing.T) {
+	dir := t.TempDir()
+	opts := jankdb.StoreOptions{
+		FileName:        "config.json",
+		EncryptionKey:   "old passphrase",
+		MergeOnConflict: true,
+	}
+	s, _ := jankdb.NewStore[map[string]string](jankdb.OSFileSystem{}, dir, opts)
+	s.Set(map[string]string{"theme": "light"})
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// Another writer saves under the old key before we rekey
+	other, _ := jankdb.NewStore[map[string]string](jankdb.OSFileSystem{}, dir, opts)
+	if err := other.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	other.Set(map[string]string{"theme": "light", "lang": "en"})
+	if err := other.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	s.Set(map[string]string{"theme": "dark"})
+	if err := s.Rekey("new passphrase"); err != nil {
+		t.Fatalf("Rekey failed: %v", err)
+	}
+	// Nothing changed on disk since, so Save must not try to merge again
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save after Rekey failed: %v", err)
+	}
+
+	opts.EncryptionKey = "new passphrase"
+	reopened, _ := jankdb.NewStore[map[string]string](jankdb.OSFileSystem{}, dir, opts)
+	if err := reopened.Load(); err != nil {
+		t.Fatalf("Load with new key failed: %v", err)
+	}
+	if got := reopened.Get(); got["theme"] != "dark" || got["lang"] != "en" {
+		t.Errorf("expected both changes merged, got %v", got)
+	}
+}
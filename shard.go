@@ -0,0 +1,454 @@
+package jankdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ShardedStore keeps a map[string]V spread over a fixed number of files
+// (shards) instead of one, for collections too big to rewrite on every save.
+// Keys are hashed to pick their shard; a shard is read the first time one of
+// its keys is touched, and Save only rewrites the shards that changed.
+//
+// With opts.FileName "users.json" and 16 shards, the files are
+// users-0000-of-0016.json ... users-0015-of-0016.json, plus users.shards.json
+// recording the shard count. Opening an existing set of shards with a
+// different count reshards it, see Reshard.
+type ShardedStore[V any] struct {
+	mu       sync.Mutex
+	fs       FileSystem
+	basePath string
+	opts     StoreOptions // template for every shard's store
+	isolate  bool
+
+	n      int                    // wanted shard count
+	meta   *Store[shardMeta]      // the shard count on disk
+	shards []*Store[map[string]V] // one per shard, for the current count
+	loaded []bool                 // shards[i] has been read
+	ready  bool                   // meta has been read (and any reshard done)
+	closed bool
+}
+
+// shardMeta is the content of the .shards.json file.
+type shardMeta struct {
+	Shards int `json:"shards"`
+}
+
+// NewShardedStore creates a sharded store with the given number of shards.
+// opts apply to every shard file; FileName names the set (see ShardedStore).
+// Nothing is read until the first access.
+func NewShardedStore[V any](fs FileSystem, basePath string, shards int, opts StoreOptions) (*ShardedStore[V], error) {
+	if shards < 1 {
+		return nil, fmt.Errorf("invalid shard count %d", shards)
+	}
+	if err := validatePathOptions(fs, opts); err != nil {
+		return nil, err
+	}
+	if opts.AutoSave {
+		// Shards saved on their own would bypass the transaction in Save
+		return nil, errors.New("ShardedStore doesn't support AutoSave; call Save")
+	}
+	if opts.Isolate {
+		if err := checkClonable[V](); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &ShardedStore[V]{
+		fs:       fs,
+		basePath: basePath,
+		opts:     opts,
+		isolate:  opts.Isolate,
+		n:        shards,
+	}
+	// The sharded store isolates values itself; shards hand out their maps
+	s.opts.Isolate = false
+
+	metaOpts := s.opts
+	metaOpts.FileName = s.stem() + ".shards.json"
+	meta, err := NewStore[shardMeta](fs, basePath, metaOpts)
+	if err != nil {
+		return nil, err
+	}
+	s.meta = meta
+	return s, nil
+}
+
+// stem is FileName without its extension.
+func (s *ShardedStore[V]) stem() string {
+	return strings.TrimSuffix(s.opts.FileName, filepath.Ext(s.opts.FileName))
+}
+
+func (s *ShardedStore[V]) shardName(i, n int) string {
+	return fmt.Sprintf("%s-%04d-of-%04d%s", s.stem(), i, n, filepath.Ext(s.opts.FileName))
+}
+
+// shardFileRE matches the names shardName makes.
+var shardFileRE = regexp.MustCompile(`-[0-9]{4,}-of-[0-9]{4,}(\.[^.]*)?$`)
+
+// isShardSetFile reports whether name is one of a ShardedStore's files (a
+// shard, or the set's .shards.json) rather than a store of its own.
+func isShardSetFile(name string) bool {
+	return strings.HasSuffix(name, ".shards.json") || shardFileRE.MatchString(name)
+}
+
+// openShards creates (but doesn't load) the stores for a set of n shards.
+func (s *ShardedStore[V]) openShards(n int) ([]*Store[map[string]V], error) {
+	shards := make([]*Store[map[string]V], n)
+	for i := range shards {
+		opts := s.opts
+		opts.FileName = s.shardName(i, n)
+		shard, err := NewStore[map[string]V](s.fs, s.basePath, opts)
+		if err != nil {
+			return nil, err
+		}
+		shards[i] = shard
+	}
+	return shards, nil
+}
+
+// shardFor returns the index of key's shard among n.
+func shardFor(key string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// ensureReady reads the shard count from disk, resharding if it isn't the
+// wanted one; the caller must hold s.mu.
+func (s *ShardedStore[V]) ensureReady() error {
+	if s.closed {
+		return ErrClosed
+	}
+	if s.ready {
+		return nil
+	}
+
+	if err := s.meta.Load(); err != nil {
+		return fmt.Errorf("failed to load shard count: %w", err)
+	}
+	onDisk := s.meta.Get().Shards
+	if onDisk == 0 {
+		// New set of shards; the count is written with the first Save
+		onDisk = s.n
+		s.meta.Set(shardMeta{Shards: s.n})
+	}
+
+	shards, err := s.openShards(onDisk)
+	if err != nil {
+		return err
+	}
+	s.shards = shards
+	s.loaded = make([]bool, onDisk)
+	s.ready = true
+
+	if onDisk != s.n {
+		return s.reshard(s.n)
+	}
+	return nil
+}
+
+// shard returns key's shard, loading it if needed; the caller must hold s.mu.
+func (s *ShardedStore[V]) shard(key string) (*Store[map[string]V], error) {
+	if err := s.ensureReady(); err != nil {
+		return nil, err
+	}
+	return s.loadShard(shardFor(key, len(s.shards)))
+}
+
+func (s *ShardedStore[V]) loadShard(i int) (*Store[map[string]V], error) {
+	shard := s.shards[i]
+	if !s.loaded[i] {
+		if err := shard.Load(); err != nil {
+			return nil, fmt.Errorf("failed to load shard %d: %w", i, err)
+		}
+		s.loaded[i] = true
+	}
+	return shard, nil
+}
+
+// loadAll loads every shard; the caller must hold s.mu.
+func (s *ShardedStore[V]) loadAll() error {
+	if err := s.ensureReady(); err != nil {
+		return err
+	}
+	for i := range s.shards {
+		if _, err := s.loadShard(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isolated returns a private copy of v if Isolate is set.
+func (s *ShardedStore[V]) isolated(v V) (V, error) {
+	if !s.isolate {
+		return v, nil
+	}
+	return clone(v)
+}
+
+// Get returns the value for key, loading its shard on first use.
+func (s *ShardedStore[V]) Get(key string) (V, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var zero V
+	shard, err := s.shard(key)
+	if err != nil {
+		return zero, false, err
+	}
+	v, ok := shard.Get()[key]
+	if !ok {
+		return zero, false, nil
+	}
+	if v, err = s.isolated(v); err != nil {
+		return zero, false, err
+	}
+	return v, true, nil
+}
+
+// Set stores val under key in memory; call Save to persist it.
+func (s *ShardedStore[V]) Set(key string, val V) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shard, err := s.shard(key)
+	if err != nil {
+		return err
+	}
+	if val, err = s.isolated(val); err != nil {
+		return err
+	}
+	return modifyShard(shard, func(m map[string]V) bool {
+		m[key] = val
+		return true
+	})
+}
+
+// Delete removes key in memory; call Save to persist it.
+func (s *ShardedStore[V]) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shard, err := s.shard(key)
+	if err != nil {
+		return err
+	}
+	return modifyShard(shard, func(m map[string]V) bool {
+		if _, ok := m[key]; !ok {
+			return false
+		}
+		delete(m, key)
+		return true
+	})
+}
+
+// modifyShard lets fn change shard's map in place, rather than copy the
+// whole map for one key, and marks the shard dirty if fn says so.
+func modifyShard[V any](shard *Store[map[string]V], fn func(m map[string]V) bool) error {
+	return shard.modify(context.Background(), func(m *map[string]V) bool {
+		if *m == nil {
+			*m = make(map[string]V)
+		}
+		return fn(*m)
+	})
+}
+
+// newTx starts a transaction over stores, which all use s.fs; it's passed
+// explicitly so a FileSystem that can't be compared still works.
+func (s *ShardedStore[V]) newTx(stores ...Transactional) *Tx {
+	return &Tx{stores: stores, fs: s.fs}
+}
+
+// Len returns the number of keys. It loads every shard.
+func (s *ShardedStore[V]) Len() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.loadAll(); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, shard := range s.shards {
+		n += len(shard.Get())
+	}
+	return n, nil
+}
+
+// Range calls fn for every key and value, in no particular order, until fn
+// returns false. It loads every shard; fn must not call back into s.
+func (s *ShardedStore[V]) Range(fn func(key string, val V) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.loadAll(); err != nil {
+		return err
+	}
+	for _, shard := range s.shards {
+		for k, v := range shard.Get() {
+			v, err := s.isolated(v)
+			if err != nil {
+				return err
+			}
+			if !fn(k, v) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// Shards returns the number of shards.
+func (s *ShardedStore[V]) Shards() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n
+}
+
+// Save writes the shards that changed since they were loaded or saved, in
+// one transaction (see Tx). Untouched shards aren't rewritten.
+func (s *ShardedStore[V]) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.ensureReady(); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func (s *ShardedStore[V]) save() error {
+	var dirty []Transactional
+	if s.meta.IsDirty() {
+		dirty = append(dirty, s.meta)
+	}
+	for i, shard := range s.shards {
+		if s.loaded[i] && shard.IsDirty() {
+			dirty = append(dirty, shard)
+		}
+	}
+	if len(dirty) == 0 {
+		return nil
+	}
+	if err := s.newTx(dirty...).Commit(); err != nil {
+		return fmt.Errorf("failed to save shards: %w", err)
+	}
+	return nil
+}
+
+// Reshard redistributes every key over n shards. The new shard files and
+// the new count are written in one transaction, after which the old files
+// are removed; a crash before the commit leaves the old shards in use.
+func (s *ShardedStore[V]) Reshard(n int) error {
+	if n < 1 {
+		return fmt.Errorf("invalid shard count %d", n)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := s.n
+	s.n = n // so a first ensureReady reshards straight to n
+	if err := s.ensureReady(); err != nil {
+		if s.ready {
+			s.n = len(s.shards)
+		} else {
+			s.n = prev
+		}
+		return err
+	}
+	if len(s.shards) == n {
+		return nil
+	}
+	return s.reshard(n)
+}
+
+// reshard does the work of Reshard; the caller must hold s.mu and ensureReady
+// must have run. If it fails, the old shards stay in use.
+func (s *ShardedStore[V]) reshard(n int) (err error) {
+	old := s.shards
+	oldMeta := s.meta.Get()
+	defer func() {
+		if err != nil {
+			// Don't let a later Save record a count the files don't have
+			s.n = len(old)
+			s.meta.Set(oldMeta)
+		}
+	}()
+	if err := s.loadAll(); err != nil {
+		return err
+	}
+
+	parts := make([]map[string]V, n)
+	for i := range parts {
+		parts[i] = make(map[string]V)
+	}
+	for _, shard := range old {
+		for k, v := range shard.Get() {
+			parts[shardFor(k, n)][k] = v
+		}
+	}
+
+	shards, err := s.openShards(n)
+	if err != nil {
+		return err
+	}
+	// Every new shard is written, even if empty, so leftovers from an
+	// interrupted reshard to the same count can't resurface
+	tx := s.newTx(s.meta)
+	for i, shard := range shards {
+		shard.Set(parts[i])
+		tx.Add(shard)
+	}
+	s.meta.Set(shardMeta{Shards: n})
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to reshard %d -> %d: %w", len(old), n, err)
+	}
+
+	s.n = n
+	s.shards = shards
+	s.loaded = make([]bool, n)
+	for i := range s.loaded {
+		s.loaded[i] = true
+	}
+
+	// The new shards are committed; the old files are just garbage now
+	for _, shard := range old {
+		_ = shard.Close()
+		_ = s.fs.Remove(shard.filePath())
+	}
+	return nil
+}
+
+// Close saves any changed shards and closes them. Using s afterwards returns
+// ErrClosed. If the save fails, s stays open and Close can be retried.
+func (s *ShardedStore[V]) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	if s.ready {
+		// Only after the transaction: a shard's own Close would flush it alone
+		if err := s.save(); err != nil {
+			return err
+		}
+		for _, shard := range s.shards {
+			if err := shard.Close(); err != nil && !errors.Is(err, ErrClosed) {
+				return err
+			}
+		}
+	}
+	if err := s.meta.Close(); err != nil && !errors.Is(err, ErrClosed) {
+		return err
+	}
+	s.closed = true
+	return nil
+}
@@ -0,0 +1,301 @@
+package jankdb_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/guarzo/jankdb"
+)
+
+// countingFS records which files are read and written, and fails writes
+// to files containing failOn.
+type countingFS struct {
+	jankdb.OSFileSystem
+	mu     sync.Mutex
+	reads  []string
+	writes []string
+	failOn string
+}
+
+func (c *countingFS) ReadFile(path string) ([]byte, error) {
+	c.mu.Lock()
+	c.reads = append(c.reads, filepath.Base(path))
+	c.mu.Unlock()
+	return c.OSFileSystem.ReadFile(path)
+}
+
+func (c *countingFS) WriteFile(path string, data []byte, perm os.FileMode) error {
+	c.mu.Lock()
+	c.writes = append(c.writes, filepath.Base(path))
+	failOn := c.failOn
+	c.mu.Unlock()
+	if failOn != "" && strings.Contains(path, failOn) {
+		return fmt.Errorf("simulated failure writing %s", path)
+	}
+	return c.OSFileSystem.WriteFile(path, data, perm)
+}
+
+// shardFiles counts the shard files (including staged ones, but not the
+// count file) among names.
+func shardFiles(names []string) int {
+	n := 0
+	for _, name := range names {
+		if strings.HasPrefix(name, "users-") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestShardedStore(t *testing.T) {
+	dir := t.TempDir()
+	fs := &countingFS{}
+	opts := jankdb.StoreOptions{SubDir: "users", FileName: "users.json"}
+
+	s, err := jankdb.NewShardedStore[int](fs, dir, 4, opts)
+	if err != nil {
+		t.Fatalf("NewShardedStore failed: %v", err)
+	}
+	for i := range 100 {
+		if err := s.Set(fmt.Sprintf("user-%d", i), i); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	if err := s.Delete("user-99"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	for i := range 4 {
+		name := fmt.Sprintf("users-%04d-of-0004.json", i)
+		if _, err := os.Stat(filepath.Join(dir, "users", name)); err != nil {
+			t.Errorf("expected shard file %s: %v", name, err)
+		}
+	}
+
+	// A fresh store reads only the shard it needs
+	fs.reads = nil
+	s2, _ := jankdb.NewShardedStore[int](fs, dir, 4, opts)
+	v, ok, err := s2.Get("user-7")
+	if err != nil || !ok || v != 7 {
+		t.Fatalf("expected user-7 = 7, got %d, %v, %v", v, ok, err)
+	}
+	if n := shardFiles(fs.reads); n != 1 {
+		t.Errorf("expected 1 shard read, got %d (%v)", n, fs.reads)
+	}
+	if _, ok, _ := s2.Get("user-99"); ok {
+		t.Error("expected deleted key to be gone")
+	}
+
+	// Only the changed shard is rewritten
+	fs.writes = nil
+	if err := s2.Set("user-7", 700); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := s2.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if n := shardFiles(fs.writes); n != 1 {
+		t.Errorf("expected 1 shard written, got %d (%v)", n, fs.writes)
+	}
+
+	if n, err := s2.Len(); err != nil || n != 99 {
+		t.Errorf("expected 99 keys, got %d, %v", n, err)
+	}
+	if err := s2.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, _, err := s2.Get("user-1"); err != jankdb.ErrClosed {
+		t.Errorf("expected ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestShardedStore_Reshard(t *testing.T) {
+	dir := t.TempDir()
+	opts := jankdb.StoreOptions{FileName: "users.json"}
+
+	s, _ := jankdb.NewShardedStore[int](jankdb.OSFileSystem{}, dir, 4, opts)
+	for i := range 50 {
+		_ = s.Set(fmt.Sprintf("user-%d", i), i)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Opening with another count reshards on first use
+	s2, _ := jankdb.NewShardedStore[int](jankdb.OSFileSystem{}, dir, 8, opts)
+	if v, ok, err := s2.Get("user-3"); err != nil || !ok || v != 3 {
+		t.Fatalf("expected user-3 = 3 after reshard, got %d, %v, %v", v, ok, err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "users-*.json"))
+	if len(matches) != 8 {
+		t.Errorf("expected 8 shard files after reshard, got %v", matches)
+	}
+
+	if err := s2.Reshard(2); err != nil {
+		t.Fatalf("Reshard failed: %v", err)
+	}
+	if s2.Shards() != 2 {
+		t.Errorf("expected 2 shards, got %d", s2.Shards())
+	}
+	matches, _ = filepath.Glob(filepath.Join(dir, "users-*-of-0002.json"))
+	all, _ := filepath.Glob(filepath.Join(dir, "users-*.json"))
+	if len(matches) != 2 || len(all) != 2 {
+		t.Errorf("expected only the 2 new shard files, got %v", all)
+	}
+
+	sum := 0
+	if err := s2.Range(func(key string, v int) bool {
+		sum += v
+		return true
+	}); err != nil {
+		t.Fatalf("Range failed: %v", err)
+	}
+	if sum != 49*50/2 {
+		t.Errorf("expected every value to survive resharding, sum %d", sum)
+	}
+
+	// Reopening with the same count needs no reshard
+	s3, _ := jankdb.NewShardedStore[int](jankdb.OSFileSystem{}, dir, 2, opts)
+	if n, err := s3.Len(); err != nil || n != 50 {
+		t.Errorf("expected 50 keys, got %d, %v", n, err)
+	}
+}
+
+func TestShardedStore_FailedReshardKeepsData(t *testing.T) {
+	dir := t.TempDir()
+	fs := &countingFS{}
+	opts := jankdb.StoreOptions{FileName: "users.json"}
+	s, _ := jankdb.NewShardedStore[int](fs, dir, 2, opts)
+	for i := range 20 {
+		_ = s.Set(fmt.Sprintf("user%d", i), i)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	fs.failOn = "-of-0004"
+	if err := s.Reshard(4); err == nil {
+		t.Fatal("expected Reshard to fail")
+	}
+	if s.Shards() != 2 {
+		t.Errorf("expected 2 shards after a failed reshard, got %d", s.Shards())
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	fs.failOn = ""
+	s2, _ := jankdb.NewShardedStore[int](fs, dir, 2, opts)
+	defer s2.Close()
+	n, err := s2.Len()
+	if err != nil {
+		t.Fatalf("Len failed: %v", err)
+	}
+	if n != 20 {
+		t.Errorf("expected 20 keys to survive a failed reshard, got %d", n)
+	}
+}
+
+func TestShardedStore_UncomparableFileSystem(t *testing.T) {
+	dir := t.TempDir()
+	opts := jankdb.StoreOptions{FileName: "users.json"}
+	s, err := jankdb.NewShardedStore[int](mapFS{tags: map[string]string{}}, dir, 4, opts)
+	if err != nil {
+		t.Fatalf("NewShardedStore failed: %v", err)
+	}
+	for i := range 10 {
+		_ = s.Set(fmt.Sprintf("user%d", i), i)
+	}
+	if err := s.Reshard(2); err != nil {
+		t.Fatalf("Reshard failed: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	s2, _ := jankdb.NewShardedStore[int](mapFS{}, dir, 2, opts)
+	defer s2.Close()
+	if v, ok, err := s2.Get("user7"); err != nil || !ok || v != 7 {
+		t.Errorf("expected 7, got %d, %v, %v", v, ok, err)
+	}
+}
+
+// shardEvents collects audit events in memory.
+type shardEvents struct {
+	mu  sync.Mutex
+	ops []string
+}
+
+func (e *shardEvents) Record(event jankdb.AuditEvent) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ops = append(e.ops, string(event.Op)+":"+filepath.Base(event.Store))
+	return nil
+}
+
+func TestShardedStore_AuditsChanges(t *testing.T) {
+	events := &shardEvents{}
+	s, _ := jankdb.NewShardedStore[int](jankdb.OSFileSystem{}, t.TempDir(), 1, jankdb.StoreOptions{
+		FileName: "users.json",
+		Audit:    events,
+	})
+	_ = s.Set("alice", 1)
+	_ = s.Delete("nobody") // no change, no record
+	_ = s.Delete("alice")
+	defer s.Close()
+
+	got := strings.Join(events.ops, ",")
+	if want := "set:users-0000-of-0001.json,set:users-0000-of-0001.json"; !strings.Contains(got, want) {
+		t.Errorf("expected %s among the audit events, got %s", want, got)
+	}
+}
+
+func TestShardedStore_RejectsAutoSave(t *testing.T) {
+	_, err := jankdb.NewShardedStore[int](jankdb.OSFileSystem{}, t.TempDir(), 2, jankdb.StoreOptions{
+		FileName: "users.json",
+		AutoSave: true,
+	})
+	if err == nil {
+		t.Fatal("expected AutoSave to be rejected")
+	}
+}
+
+func TestShardedStore_FailedCloseIsRetryable(t *testing.T) {
+	dir := t.TempDir()
+	fs := &countingFS{}
+	opts := jankdb.StoreOptions{FileName: "users.json"}
+	s, _ := jankdb.NewShardedStore[int](fs, dir, 2, opts)
+	for i := range 10 {
+		_ = s.Set(fmt.Sprintf("user%d", i), i)
+	}
+
+	fs.failOn = "users-0001"
+	if err := s.Close(); err == nil {
+		t.Fatal("expected Close to fail")
+	}
+	// Nothing was written outside the failed transaction
+	if _, err := os.Stat(filepath.Join(dir, "users-0000-of-0002.json")); !os.IsNotExist(err) {
+		t.Errorf("expected no shard on disk after a failed Close, got %v", err)
+	}
+
+	fs.failOn = ""
+	if err := s.Close(); err != nil {
+		t.Fatalf("retried Close failed: %v", err)
+	}
+	if err := s.Close(); !errors.Is(err, jankdb.ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+
+	s2, _ := jankdb.NewShardedStore[int](fs, dir, 2, opts)
+	defer s2.Close()
+	if n, err := s2.Len(); err != nil || n != 10 {
+		t.Errorf("expected 10 keys, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,327 @@
+package jankdb
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// snapshotManifestName is the first entry of every snapshot archive.
+const snapshotManifestName = "jankdb-manifest.json"
+
+// ErrInvalidSnapshot is returned by Import when an archive is malformed or
+// doesn't match its manifest.
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// ExportOptions controls Export.
+type ExportOptions struct {
+	// If true, the tar archive is gzip-compressed.
+	Gzip bool
+}
+
+// defaultMaxImportSize is ImportOptions.MaxSize when unset.
+const defaultMaxImportSize = 1 << 30
+
+// ImportOptions controls Import.
+type ImportOptions struct {
+	// Mode for directories Import creates (default 0755), see StoreOptions.DirMode.
+	DirMode os.FileMode
+
+	// Most bytes of file data the archive may hold, as it's read into
+	// memory before anything is written (default 1 GiB).
+	MaxSize int64
+}
+
+// SnapshotManifest describes the files in a snapshot archive.
+type SnapshotManifest struct {
+	Created time.Time       `json:"created"`
+	Files   []SnapshotEntry `json:"files"`
+}
+
+// SnapshotEntry is one file in a snapshot. Name is slash-separated and
+// relative to the base path. SchemaVersion is only known for unencrypted
+// files written with a SchemaVersion.
+type SnapshotEntry struct {
+	Name          string `json:"name"`
+	Size          int64  `json:"size"`
+	SHA256        string `json:"sha256"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
+}
+
+// Export writes every file under basePath (except in-progress temp files)
+// to w as a tar archive, preceded by a manifest of names, checksums and
+// schema versions. Files are copied as they are on disk, so encrypted stores
+// stay encrypted. Save or Close stores first to include unsaved changes.
+func Export(fs FileSystem, basePath string, w io.Writer, opts ExportOptions) error {
+	files, err := collectFiles(fs, basePath, "")
+	if err != nil {
+		return err
+	}
+
+	manifest := SnapshotManifest{Created: time.Now().UTC()}
+	contents := make([][]byte, len(files))
+	for i, name := range files {
+		data, err := fs.ReadFile(filepath.Join(basePath, filepath.FromSlash(name)))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		contents[i] = data
+		var meta envelopeMeta
+		if plain, err := decompress(data); err == nil {
+			_, meta = unwrapEnvelope(plain)
+		}
+		manifest.Files = append(manifest.Files, SnapshotEntry{
+			Name:          name,
+			Size:          int64(len(data)),
+			SHA256:        hashBytes(data),
+			SchemaVersion: meta.Schema,
+		})
+	}
+
+	out := w
+	var gz *gzip.Writer
+	if opts.Gzip {
+		gz = gzip.NewWriter(w)
+		out = gz
+	}
+	tw := tar.NewWriter(out)
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot manifest: %w", err)
+	}
+	if err := writeTarEntry(tw, snapshotManifestName, manifestData, manifest.Created); err != nil {
+		return err
+	}
+	for i, name := range files {
+		if err := writeTarEntry(tw, name, contents[i], manifest.Created); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish tar archive: %w", err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("failed to finish gzip stream: %w", err)
+		}
+	}
+	return nil
+}
+
+// Import restores a snapshot written by Export into basePath. The archive
+// (gzip-compressed or not) is read and checked against its manifest before
+// anything is written, and the files are then replaced in one transaction.
+// Files under basePath that aren't in the snapshot are left alone.
+func Import(fs FileSystem, basePath string, r io.Reader, opts ImportOptions) error {
+	if opts.DirMode == 0 {
+		opts.DirMode = 0755
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultMaxImportSize
+	}
+	remaining := opts.MaxSize
+
+	br := bufio.NewReader(r)
+	var in io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+		}
+		defer gz.Close()
+		in = gz
+	}
+
+	var manifest *SnapshotManifest
+	contents := make(map[string][]byte)
+	tr := tar.NewReader(in)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return fmt.Errorf("%w: unexpected entry %s", ErrInvalidSnapshot, hdr.Name)
+		}
+		if hdr.Size > remaining {
+			return fmt.Errorf("%w: archive holds more than %d bytes", ErrInvalidSnapshot, opts.MaxSize)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, hdr.Size))
+		if err != nil {
+			return fmt.Errorf("%w: failed to read %s: %v", ErrInvalidSnapshot, hdr.Name, err)
+		}
+		remaining -= int64(len(data))
+
+		if hdr.Name == snapshotManifestName {
+			manifest = &SnapshotManifest{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return fmt.Errorf("%w: bad manifest: %v", ErrInvalidSnapshot, err)
+			}
+			continue
+		}
+		if _, dup := contents[hdr.Name]; dup {
+			return fmt.Errorf("%w: duplicate entry %s", ErrInvalidSnapshot, hdr.Name)
+		}
+		contents[hdr.Name] = data
+	}
+	if manifest == nil {
+		return fmt.Errorf("%w: no manifest", ErrInvalidSnapshot)
+	}
+
+	// Validate everything before touching the disk
+	if len(manifest.Files) != len(contents) {
+		return fmt.Errorf("%w: manifest lists %d files, archive has %d", ErrInvalidSnapshot, len(manifest.Files), len(contents))
+	}
+	files := make([]txFile, 0, len(manifest.Files))
+	for _, entry := range manifest.Files {
+		if err := validateRelPath("snapshot entry", filepath.FromSlash(entry.Name)); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+		}
+		// Temp files and manifests would be acted on by the next Load
+		if entry.Name == snapshotManifestName || isReservedPath(entry.Name) {
+			return fmt.Errorf("%w: reserved name %s", ErrInvalidSnapshot, entry.Name)
+		}
+		data, ok := contents[entry.Name]
+		if !ok {
+			return fmt.Errorf("%w: %s is missing", ErrInvalidSnapshot, entry.Name)
+		}
+		if int64(len(data)) != entry.Size || hashBytes(data) != entry.SHA256 {
+			return fmt.Errorf("%w: checksum mismatch for %s", ErrInvalidSnapshot, entry.Name)
+		}
+		files = append(files, txFile{path: filepath.Join(basePath, filepath.FromSlash(entry.Name)), data: data})
+	}
+
+	for _, f := range files {
+		dir := filepath.Dir(f.path)
+		if err := fs.MkdirAll(dir, opts.DirMode); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+	}
+	if err := fs.MkdirAll(basePath, opts.DirMode); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", basePath, err)
+	}
+	if err := recoverTransactions(fs, basePath); err != nil {
+		return err
+	}
+	return commitFiles(context.Background(), fs, basePath, files)
+}
+
+// collectFiles lists files under basePath/rel (slash-separated, sorted),
+// skipping temp files and transaction manifests.
+func collectFiles(fs FileSystem, basePath, rel string) ([]string, error) {
+	dir := filepath.Join(basePath, filepath.FromSlash(rel))
+	entries, err := fs.ReadDir(dir)
+	if err != nil {
+		if rel == "" && fs.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read directory %s: %w", dir, err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			sub, err := collectFiles(fs, basePath, path.Join(rel, name))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, sub...)
+			continue
+		}
+		if isReservedName(name) {
+			continue
+		}
+		files = append(files, path.Join(rel, name))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// isReservedName reports whether a file name belongs to jankdb's own
+// bookkeeping (temp files, transaction manifests) rather than a store.
+func isReservedName(name string) bool {
+	return isTempName(name) || strings.HasPrefix(name, txManifestPrefix)
+}
+
+// isReservedPath is isReservedName for any element of a slash-separated path.
+func isReservedPath(p string) bool {
+	for _, name := range strings.Split(p, "/") {
+		if isReservedName(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeTarEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0600,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write tar header for %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s to tar: %w", name, err)
+	}
+	return nil
+}
+
+// Export flushes every open store and writes a snapshot of the DB's base path.
+func (db *DB) Export(w io.Writer, opts ExportOptions) error {
+	if err := db.Flush(); err != nil {
+		return err
+	}
+	return Export(db.fs, db.basePath, w, opts)
+}
+
+// Import restores a snapshot into the DB's base path and reloads every open
+// store from the restored files. It refuses to run while an open store has
+// unsaved changes, which the reload would throw away: Flush first, or Load
+// the store to drop them. opts.DirMode defaults to the DB's DirMode.
+func (db *DB) Import(r io.Reader, opts ImportOptions) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	for name, s := range db.stores {
+		if s.IsDirty() {
+			return fmt.Errorf("store %q has unsaved changes", name)
+		}
+	}
+
+	if opts.DirMode == 0 {
+		opts.DirMode = db.defaults.DirMode
+	}
+	if err := Import(db.fs, db.basePath, r, opts); err != nil {
+		return err
+	}
+
+	var errs []error
+	for name, s := range db.stores {
+		if err := s.Load(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to reload store %q: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,187 @@
+package jankdb_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/guarzo/jankdb"
+)
+
+func TestExportImport(t *testing.T) {
+	fs := jankdb.OSFileSystem{}
+	src := t.TempDir()
+
+	db, _ := jankdb.NewDB(fs, src, jankdb.StoreOptions{SchemaVersion: 1})
+	loot, _ := jankdb.Open[map[string]int](db, "loot")
+	loot.Set(map[string]int{"gold": 10})
+	secrets, _ := jankdb.NewStore[string](fs, src, jankdb.StoreOptions{
+		SubDir:        "app",
+		FileName:      "secrets.json",
+		EncryptionKey: "pass123",
+	})
+	secrets.Set("shh")
+	_ = secrets.Save()
+
+	var buf bytes.Buffer
+	if err := db.Export(&buf, jankdb.ExportOptions{Gzip: true}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	dst := t.TempDir()
+	if err := jankdb.Import(fs, dst, bytes.NewReader(buf.Bytes()), jankdb.ImportOptions{}); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	db2, _ := jankdb.NewDB(fs, dst, jankdb.StoreOptions{SchemaVersion: 1})
+	loot2, err := jankdb.Open[map[string]int](db2, "loot")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if loot2.Get()["gold"] != 10 {
+		t.Errorf("expected gold 10, got %v", loot2.Get())
+	}
+	secrets2, _ := jankdb.NewStore[string](fs, dst, jankdb.StoreOptions{
+		SubDir:        "app",
+		FileName:      "secrets.json",
+		EncryptionKey: "pass123",
+	})
+	if err := secrets2.Load(); err != nil || secrets2.Get() != "shh" {
+		t.Errorf("expected encrypted store to round-trip, got %q (%v)", secrets2.Get(), err)
+	}
+}
+
+func TestImport_RejectsTampered(t *testing.T) {
+	fs := jankdb.OSFileSystem{}
+	src := t.TempDir()
+	s, _ := jankdb.NewStore[int](fs, src, jankdb.StoreOptions{FileName: "n.json"})
+	s.Set(1)
+	_ = s.Save()
+
+	var buf bytes.Buffer
+	if err := jankdb.Export(fs, src, &buf, jankdb.ExportOptions{}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	// Rewrite the archive with different file contents but the same manifest
+	var tampered bytes.Buffer
+	tr := tar.NewReader(&buf)
+	tw := tar.NewWriter(&tampered)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		data, _ := io.ReadAll(tr)
+		if hdr.Name == "n.json" {
+			data = []byte("2")
+			hdr.Size = 1
+		}
+		_ = tw.WriteHeader(hdr)
+		_, _ = tw.Write(data)
+	}
+	_ = tw.Close()
+
+	dst := t.TempDir()
+	if err := jankdb.Import(fs, dst, &tampered, jankdb.ImportOptions{}); !errors.Is(err, jankdb.ErrInvalidSnapshot) {
+		t.Errorf("expected ErrInvalidSnapshot, got %v", err)
+	}
+}
+
+func TestImport_RejectsReservedNames(t *testing.T) {
+	evil := []byte(`{"files":[{"staged":"payload","final":"../victim.txt"}]}`)
+	for _, name := range []string{
+		".jankdb-txn-evil.json",
+		"n.json.tmp",
+		"sub/n.json.tmp-0123",
+		"jankdb-manifest.json",
+	} {
+		t.Run(name, func(t *testing.T) {
+			sum := sha256.Sum256(evil)
+			manifest, _ := json.Marshal(jankdb.SnapshotManifest{Files: []jankdb.SnapshotEntry{
+				{Name: name, Size: int64(len(evil)), SHA256: hex.EncodeToString(sum[:])},
+			}})
+			var archive bytes.Buffer
+			tw := tar.NewWriter(&archive)
+			for _, e := range []struct {
+				name string
+				data []byte
+			}{{"jankdb-manifest.json", manifest}, {name, evil}} {
+				_ = tw.WriteHeader(&tar.Header{Name: e.name, Mode: 0600, Size: int64(len(e.data)), Typeflag: tar.TypeReg})
+				_, _ = tw.Write(e.data)
+			}
+			_ = tw.Close()
+
+			dst := t.TempDir()
+			if err := jankdb.Import(jankdb.OSFileSystem{}, dst, &archive, jankdb.ImportOptions{}); !errors.Is(err, jankdb.ErrInvalidSnapshot) {
+				t.Errorf("expected ErrInvalidSnapshot, got %v", err)
+			}
+			if entries, _ := os.ReadDir(dst); len(entries) != 0 {
+				t.Errorf("expected nothing imported, got %v", entries)
+			}
+		})
+	}
+}
+
+func TestImport_Limits(t *testing.T) {
+	fs := jankdb.OSFileSystem{}
+	src := t.TempDir()
+	s, _ := jankdb.NewStore[string](fs, src, jankdb.StoreOptions{SubDir: "app", FileName: "big.json"})
+	s.Set(string(bytes.Repeat([]byte("x"), 4096)))
+	_ = s.Save()
+	var buf bytes.Buffer
+	if err := jankdb.Export(fs, src, &buf, jankdb.ExportOptions{Gzip: true}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	dst := t.TempDir()
+	err := jankdb.Import(fs, dst, bytes.NewReader(buf.Bytes()), jankdb.ImportOptions{MaxSize: 1024})
+	if !errors.Is(err, jankdb.ErrInvalidSnapshot) {
+		t.Fatalf("expected an oversized archive to be refused, got %v", err)
+	}
+
+	if err := jankdb.Import(fs, dst, bytes.NewReader(buf.Bytes()), jankdb.ImportOptions{DirMode: 0o700}); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dst, "app"))
+	if err != nil || info.Mode().Perm() != 0o700 {
+		t.Errorf("expected app/ created with 0700, got %v (%v)", info.Mode().Perm(), err)
+	}
+}
+
+func TestDB_Import_RefusesUnsavedChanges(t *testing.T) {
+	fs := jankdb.OSFileSystem{}
+	src := t.TempDir()
+	db, _ := jankdb.NewDB(fs, src, jankdb.StoreOptions{})
+	loot, _ := jankdb.Open[int](db, "loot")
+	loot.Set(1)
+	var buf bytes.Buffer
+	if err := db.Export(&buf, jankdb.ExportOptions{}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	loot.Set(2)
+	if err := db.Import(bytes.NewReader(buf.Bytes()), jankdb.ImportOptions{}); err == nil {
+		t.Fatal("expected Import to refuse while loot has unsaved changes")
+	}
+	if loot.Get() != 2 {
+		t.Errorf("expected unsaved value to be kept, got %d", loot.Get())
+	}
+
+	if err := loot.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := db.Import(bytes.NewReader(buf.Bytes()), jankdb.ImportOptions{}); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if loot.Get() != 1 {
+		t.Errorf("expected the snapshot's value, got %d", loot.Get())
+	}
+}
@@ -1,13 +1,25 @@
 package jankdb
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
 
+// ErrClosed is returned by operations on a Store after Close.
+var ErrClosed = errors.New("store is closed")
+
 // Store[T] is a generic store for any type T.
 type Store[T any] struct {
 	mu sync.RWMutex
@@ -26,173 +38,944 @@ type Store[T any] struct {
 	// Backup old file as .bak before overwriting
 	enableBackup bool
 
+	// fsync the file and its directory on every write
+	durable bool
+
+	// Permissions for new files/directories, and optional owner
+	fileMode       os.FileMode
+	dirMode        os.FileMode
+	owner          *FileOwner
+	requirePrivate bool
+	keyFile        string
+
+	// If true => refuse to read or write through symlinks under basePath
+	noFollowSymlinks bool
+
 	// If non-empty => encrypt on write, decrypt on read
 	encryptionKey string
+	cipherFormat  CipherFormat
+
+	// If non-nil => compress on write (reads detect compression regardless)
+	compression Compressor
+
+	// If true => Save streams into the temp file, see StoreOptions.StreamWrites
+	streamWrites bool
+
+	// If > 0 => data is wrapped in an envelope carrying the schema version
+	schemaVersion int
+	migrations    map[int]migration
+
+	// If true => the envelope also carries a revision counter
+	trackRevisions bool
+	version        Version
+
+	// If set => Save merges with changes made on disk since our Load/Save.
+	// base is the JSON of the value as of that Load/Save.
+	resolver ConflictResolver[T]
+	base     []byte
+
+	// If set => used in place of the zero value when there is no file yet
+	defaultFn   func() T
+	saveDefault bool
+
+	// If true => Get returns a copy and Set stores a copy
+	isolate bool
+
+	// true when the in-memory data hasn't been written to disk yet
+	dirty bool
+
+	// If non-nil => every Save appends to the .history file. historyPrev is
+	// the JSON of the last entry we wrote, historySince the entries since
+	// the last snapshot, historySeal the key for an encrypted history file.
+	history      *HistoryOptions
+	historyPrev  []byte
+	historySince int
+	historySeal  *historySeal
+
+	// If non-nil => Set, Update, Save and Rekey are recorded. auditSaved and
+	// auditData are the content hashes of the file and of the data as last
+	// recorded, auditKey the HMAC key for encrypted stores' content hashes.
+	audit      AuditSink
+	auditSaved string
+	auditData  string
+	auditKey   []byte
+
+	// A history or audit record that failed where no error could be
+	// returned (Set, a Tx commit); the next Save returns it.
+	recordErr error
+
+	// Values before the last UndoLimit changes, newest last, and the ones
+	// Undo took back. undoLabel is the Checkpoint label of the current data.
+	undoLimit int
+	undoStack []undoEntry[T]
+	redoStack []undoEntry[T]
+	undoLabel string
+
+	// If non-nil => Set schedules a debounced Save
+	autosave *autosaver
+
+	closed bool
 }
 
 // StoreOptions defines the parameters for customizing a Store.
 type StoreOptions struct {
+	// SubDir and FileName are joined onto the base path. They must be
+	// relative and may not contain "..", so they can't escape it.
 	SubDir       string
 	FileName     string
 	EnableBackup bool
 
+	// If true, Load and Save refuse to go through a symlink anywhere between
+	// the base path and the file. Needs a FileSystem that implements SymlinkFileSystem.
+	NoFollowSymlinks bool
+
+	// If true, each Save fsyncs the new file before renaming it into place
+	// and fsyncs the directory afterwards, so a power loss can't leave an
+	// empty or half-written file. Needs a FileSystem that implements Syncer.
+	Durable bool
+
+	// Permissions for newly created files (default 0600) and directories
+	// (default 0755). Replacing an existing file keeps that file's mode.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
+	// If set, written files are chowned to this owner. Needs a FileSystem
+	// that implements PermissionFileSystem.
+	Owner *FileOwner
+
+	// If true, Load refuses to read an encrypted store when the data file or
+	// EncryptionKeyFile is readable by group or others.
+	RequirePrivateFiles bool
+
 	UseCache          bool
 	DefaultExpiration time.Duration
 	CleanupInterval   time.Duration
 
 	// If not empty, we do AES-GCM encryption using this passphrase
 	EncryptionKey string
+
+	// How encrypted data is written: base64 text (default), CipherBinary,
+	// which is a third smaller, or CipherStream for large stores. Load
+	// reads any of them.
+	CipherFormat CipherFormat
+
+	// If set, files are compressed (before encryption) on Save. Load
+	// detects compressed files by their magic bytes whatever this is set
+	// to, so compressed and uncompressed files can coexist.
+	Compression Compressor
+
+	// If true, Save encodes straight into the temp file through
+	// FileSystem.OpenFile instead of building the file in memory first,
+	// and top-level maps and slices are encoded one element at a time.
+	// Any other T, a struct included, is still marshaled whole; hold the
+	// bulk of a large store in a map or slice at the top level.
+	// Encrypted stores only stream with CipherStream, which implies this.
+	StreamWrites bool
+
+	// If not empty (and EncryptionKey is), the passphrase is read from this
+	// file; surrounding whitespace is trimmed.
+	EncryptionKeyFile string
+
+	// If > 0, the file records this schema version and Load runs any
+	// registered migrations to bring older files up to it.
+	SchemaVersion int
+
+	// If true, the file records a revision that goes up by one on every
+	// Save, alongside the content hash used by SaveIfVersion.
+	TrackRevisions bool
+
+	// If true, Save merges our changes with any saved by someone else since
+	// our last Load or Save using MergeJSON, rather than overwriting them.
+	// See SetConflictResolver for a custom merge.
+	MergeOnConflict bool
+
+	// If true, LoadOrInit writes the default value to disk as soon as it
+	// creates it (see SetDefault), instead of waiting for the next Save.
+	SaveDefault bool
+
+	// If true, Get returns a snapshot and Set keeps a private copy, so callers
+	// can't mutate the store's data (maps, slices, pointers) without the lock.
+	// T can implement Cloner to avoid the JSON round-trip; otherwise NewStore
+	// refuses types the round-trip can't copy, e.g. with unexported fields.
+	Isolate bool
+
+	// If true, Set schedules a Save instead of requiring one. Saves are
+	// debounced by AutoSaveDelay (default 1s) but never postponed longer
+	// than AutoSaveMaxDelay after the first unsaved Set (0 => no cap).
+	// Pending changes are flushed on Close.
+	AutoSave         bool
+	AutoSaveDelay    time.Duration
+	AutoSaveMaxDelay time.Duration
+
+	// Called with any error from a background autosave.
+	OnAutoSaveError func(error)
+
+	// If set, every Save also appends the new version to FileName.history,
+	// see History and At.
+	History *HistoryOptions
+
+	// If set, every Set, Update, Save and Rekey is recorded there, see
+	// AuditLog.
+	Audit AuditSink
+
+	// If > 0 => Set and Update keep the last UndoLimit values for Undo, see
+	// also Checkpoint. The values are copied as with Isolate.
+	UndoLimit int
 }
 
 // NewStore creates a new Store[T].
+// SubDir and FileName are validated, see ErrInvalidPath.
 func NewStore[T any](fs FileSystem, basePath string, opts StoreOptions) (*Store[T], error) {
+	if err := validatePathOptions(fs, opts); err != nil {
+		return nil, err
+	}
+	if opts.Isolate || opts.UndoLimit > 0 {
+		if err := checkClonable[T](); err != nil {
+			return nil, err
+		}
+	}
+
 	s := &Store[T]{
-		fs:            fs,
-		basePath:      basePath,
-		subDir:        opts.SubDir,
-		fileName:      opts.FileName,
-		enableBackup:  opts.EnableBackup,
-		encryptionKey: opts.EncryptionKey,
+		fs:               fs,
+		basePath:         basePath,
+		subDir:           opts.SubDir,
+		fileName:         opts.FileName,
+		enableBackup:     opts.EnableBackup,
+		durable:          opts.Durable,
+		fileMode:         opts.FileMode,
+		dirMode:          opts.DirMode,
+		owner:            opts.Owner,
+		requirePrivate:   opts.RequirePrivateFiles,
+		noFollowSymlinks: opts.NoFollowSymlinks,
+		encryptionKey:    opts.EncryptionKey,
+		cipherFormat:     opts.CipherFormat,
+		streamWrites:     opts.StreamWrites,
+		schemaVersion:    opts.SchemaVersion,
+		trackRevisions:   opts.TrackRevisions,
+		migrations:       make(map[int]migration),
+		saveDefault:      opts.SaveDefault,
+		isolate:          opts.Isolate,
+		history:          opts.History,
+		audit:            opts.Audit,
+		undoLimit:        opts.UndoLimit,
+	}
+	if opts.Compression != nil {
+		if err := RegisterCompressor(opts.Compression); err != nil {
+			return nil, err
+		}
+		s.compression = opts.Compression
+	}
+	if opts.MergeOnConflict {
+		s.resolver = MergeJSON[T]
+	}
+	if s.fileMode == 0 {
+		s.fileMode = 0600
+	}
+	if s.dirMode == 0 {
+		s.dirMode = 0755
+	}
+
+	if s.encryptionKey == "" && opts.EncryptionKeyFile != "" {
+		key, err := fs.ReadFile(opts.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		s.encryptionKey = strings.TrimSpace(string(key))
+		if s.encryptionKey == "" {
+			return nil, fmt.Errorf("encryption key file %s is empty", opts.EncryptionKeyFile)
+		}
+		s.keyFile = opts.EncryptionKeyFile
 	}
 
 	if opts.UseCache {
 		s.cache = NewCache[T](opts.DefaultExpiration, opts.CleanupInterval)
 	}
 
+	if opts.AutoSave {
+		s.autosave = newAutosaver(opts.AutoSaveDelay, opts.AutoSaveMaxDelay, s.flush, opts.OnAutoSaveError)
+	}
+
 	return s, nil
 }
 
 // Load reads T from the file. If `encryptionKey` is set, we decrypt the file first.
+// Files written with an older schema version are migrated and saved back.
+// If there is no file, the data is reset to the default (see SetDefault).
 func (s *Store[T]) Load() error {
+	return s.LoadContext(context.Background())
+}
+
+// LoadContext is like Load, but gives up waiting for the store's lock or the
+// file system once ctx is done.
+func (s *Store[T]) LoadContext(ctx context.Context) error {
+	if err := s.lockContext(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	_, err := s.load(fsWithContext(ctx, s.fs))
+	return err
+}
+
+// LoadOrInit is like Load, but also reports whether the file was missing and
+// the store was initialized from the default. Without a default (see
+// SetDefault), created is always false. With SaveDefault set, the new value
+// is written to disk right away.
+func (s *Store[T]) LoadOrInit() (created bool, err error) {
+	return s.LoadOrInitContext(context.Background())
+}
+
+// LoadOrInitContext is LoadOrInit with a context, see LoadContext.
+func (s *Store[T]) LoadOrInitContext(ctx context.Context) (created bool, err error) {
+	if err := s.lockContext(ctx); err != nil {
+		return false, err
+	}
+	defer s.mu.Unlock()
+	if s.closed {
+		return false, ErrClosed
+	}
+
+	fs := fsWithContext(ctx, s.fs)
+	created, err = s.load(fs)
+	if err != nil || !created {
+		return created, err
+	}
+	if s.saveDefault {
+		if err := s.save(ctx, fs); err != nil {
+			return true, fmt.Errorf("failed to save default data: %w", err)
+		}
+	}
+	return true, nil
+}
+
+// SetDefault sets the function used to build the initial value when the
+// store's file doesn't exist yet, e.g. `func() map[string]int { return map[string]int{} }`.
+func (s *Store[T]) SetDefault(fn func() T) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.defaultFn = fn
+}
 
+// load does the work of Load and reports whether the default was used;
+// the caller must hold s.mu.
+func (s *Store[T]) load(fs FileSystem) (bool, error) {
 	path := s.filePath()
-	if _, err := s.fs.Stat(path); s.fs.IsNotExist(err) {
-		// No file => do nothing
-		return nil
+	if err := s.checkNoSymlinks(fs); err != nil {
+		return false, err
+	}
+	if err := recoverTransactions(fs, s.basePath); err != nil {
+		return false, err
+	}
+	removeStaleTemps(fs, path)
+	s.historyPrev = nil
+	s.auditSaved, s.auditData = "", ""
+	s.resetUndo()
+
+	info, err := fs.Stat(path)
+	if fs.IsNotExist(err) {
+		// No file => start from the default (if any)
+		s.version = Version{}
+		s.base = nil
+		if s.defaultFn == nil {
+			return false, nil
+		}
+		s.data = s.defaultFn()
+		s.dirty = true
+		if s.cache != nil {
+			s.cache.Set("all", s.data)
+		}
+		return true, nil
 	} else if err != nil {
-		return fmt.Errorf("failed to stat file: %w", err)
+		return false, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if err := s.checkPrivate(fs, info); err != nil {
+		return false, err
 	}
 
-	// Read raw bytes
-	bytes, err := s.fs.ReadFile(path)
+	// Read and decode the file
+	bytes, plaintext, hash, err := s.readPlaintext(fs, path)
 	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
+		return false, err
 	}
 
-	var tmp T
-	if s.encryptionKey == "" {
-		// Plain JSON
-		if err := json.Unmarshal(bytes, &tmp); err != nil {
-			return fmt.Errorf("failed to unmarshal JSON: %w", err)
-		}
-	} else {
-		// Decrypt
-		plaintext, err := DecryptData(s.encryptionKey, string(bytes))
+	payload, meta := unwrapEnvelope(plaintext)
+	version := meta.Schema
+	migrated := false
+	if s.schemaVersion > 0 && version != s.schemaVersion {
+		payload, err = s.migrate(payload, version)
 		if err != nil {
-			return fmt.Errorf("failed to decrypt data: %w", err)
-		}
-		if err := json.Unmarshal(plaintext, &tmp); err != nil {
-			return fmt.Errorf("failed to unmarshal decrypted JSON: %w", err)
+			return false, err
 		}
+		migrated = true
+	}
+
+	var tmp T
+	if err := json.Unmarshal(payload, &tmp); err != nil {
+		return false, fmt.Errorf("failed to unmarshal JSON: %w", err)
 	}
 
 	s.data = tmp
+	s.dirty = false
+	s.version = Version{Revision: meta.Revision, Hash: hash}
+	s.rememberBase(payload)
 	if s.cache != nil {
 		s.cache.Set("all", s.data)
 	}
-	return nil
+	if s.audit != nil {
+		s.auditSaved, _ = s.contentHash(s.data)
+		s.auditData = s.auditSaved
+	}
+
+	if migrated {
+		// Keep the pre-migration file around in case the migration was wrong
+		bakPath := fmt.Sprintf("%s.v%d.bak", path, version)
+		if bytes == nil {
+			// Streamed files weren't kept in memory; migrations are rare
+			// enough to read them again.
+			if bytes, err = fs.ReadFile(path); err != nil {
+				return false, fmt.Errorf("failed to read file: %w", err)
+			}
+		}
+		if err := fs.WriteFile(bakPath, bytes, s.fileMode); err != nil {
+			return false, fmt.Errorf("failed to write pre-migration backup: %w", err)
+		}
+		if err := s.save(context.Background(), fs); err != nil {
+			return false, fmt.Errorf("failed to save migrated data: %w", err)
+		}
+	}
+	return false, nil
+}
+
+// decode turns the raw file contents into plaintext JSON, decrypting and
+// decompressing as needed.
+func (s *Store[T]) decode(raw []byte) ([]byte, error) {
+	plaintext := raw
+	if s.encryptionKey != "" {
+		var err error
+		plaintext, err = decryptAny(s.encryptionKey, raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt data: %w", err)
+		}
+	}
+	return decompress(plaintext)
+}
+
+// readPlaintext reads the file at path and decodes it. Stores using
+// CipherStream read through the decrypter instead of loading the ciphertext
+// first, and get raw == nil back; everyone else gets the file's bytes.
+// hash is always the SHA-256 of the file's bytes.
+func (s *Store[T]) readPlaintext(fs FileSystem, path string) (raw, plaintext []byte, hash string, err error) {
+	if !s.streaming() {
+		raw, err = fs.ReadFile(path)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("failed to read file: %w", err)
+		}
+		plaintext, err = s.decode(raw)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return raw, plaintext, hashBytes(raw), nil
+	}
+
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	plaintext, err = s.decodeStream(io.TeeReader(f, h))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	// Hash whatever the decoder didn't need to read
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, nil, "", fmt.Errorf("failed to read file: %w", err)
+	}
+	return nil, plaintext, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// decodeStream is decode for a reader.
+func (s *Store[T]) decodeStream(r io.Reader) ([]byte, error) {
+	pr, err := s.plaintextReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer pr.Close()
+
+	plaintext, err := io.ReadAll(pr)
+	if err != nil {
+		if errors.Is(err, ErrStreamTruncated) {
+			return nil, fmt.Errorf("failed to decrypt data: %w", err)
+		}
+		return nil, fmt.Errorf("failed to decode data: %w", err)
+	}
+	return plaintext, nil
+}
+
+// plaintextReader returns a reader of the plaintext JSON in the file r
+// reads. Only stream ciphertext is decrypted as it is read; other formats
+// are read whole first.
+func (s *Store[T]) plaintextReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	var plain io.Reader = br
+	if s.encryptionKey != "" {
+		head, _ := br.Peek(len(streamCipherMagic))
+		if bytes.Equal(head, streamCipherMagic) {
+			dr, err := NewDecryptReader(br, s.encryptionKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt data: %w", err)
+			}
+			plain = dr
+		} else {
+			raw, err := io.ReadAll(br)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read file: %w", err)
+			}
+			decrypted, err := decryptAny(s.encryptionKey, raw)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt data: %w", err)
+			}
+			plain = bytes.NewReader(decrypted)
+		}
+	}
+	return newDecompressReader(plain)
+}
+
+// streaming reports whether the file is encrypted with NewEncryptWriter.
+func (s *Store[T]) streaming() bool {
+	return s.encryptionKey != "" && s.cipherFormat == CipherStream
 }
 
 // Save writes T to disk, using atomic write & optional .bak backup.
 // If encryptionKey is not empty, data is encrypted before writing.
 func (s *Store[T]) Save() error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	return s.SaveContext(context.Background())
+}
 
-	path := s.filePath()
-	dir := filepath.Dir(path)
+// SaveContext is like Save, but gives up waiting for the store's lock or the
+// file system once ctx is done.
+func (s *Store[T]) SaveContext(ctx context.Context) error {
+	if err := s.lockContext(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	return s.save(ctx, fsWithContext(ctx, s.fs))
+}
 
-	// Ensure directory
-	if err := s.fs.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+// save does the work of Save; the caller must hold s.mu. fs is already bound
+// to ctx, which is passed for what it carries, see WithAuthor and WithActor.
+func (s *Store[T]) save(ctx context.Context, fs FileSystem) error {
+	revision, err := s.write(fs, true)
+	if err != nil {
+		return err
+	}
+
+	if s.history != nil {
+		if err := s.appendHistory(ctx, fs, revision); err != nil {
+			return fmt.Errorf("saved, but failed to record history: %w", err)
+		}
 	}
+	if err := s.auditSave(ctx); err != nil {
+		return fmt.Errorf("saved, but failed to write audit record: %w", err)
+	}
+	if err := s.recordErr; err != nil {
+		s.recordErr = nil
+		return fmt.Errorf("saved, but an earlier change failed: %w", err)
+	}
+	return nil
+}
 
-	// 1) Marshal data to JSON
-	bytes, err := json.MarshalIndent(s.data, "", "  ")
+// write encodes the data and replaces the file with it, returning the
+// revision written. It is save without the history and audit records.
+func (s *Store[T]) write(fs FileSystem, resolve bool) (uint64, error) {
+	// A crashed commit must land before anything newer, or it would
+	// overwrite it later
+	if err := recoverTransactions(fs, s.basePath); err != nil {
+		return 0, err
+	}
+	revision, err := s.prepareSave(fs, resolve)
 	if err != nil {
-		return fmt.Errorf("failed to marshal data as JSON: %w", err)
+		return 0, err
 	}
 
-	if s.encryptionKey != "" {
-		// 2) Encrypt the JSON
-		encrypted, err := EncryptData(s.encryptionKey, bytes)
+	var hash string
+	if s.streamWrites || s.streaming() {
+		// Encode straight into the temp file, hashing what goes out
+		h := sha256.New()
+		err = atomicWriteStream(fs, s.filePath(), s.writeOptions(), func(w io.Writer) error {
+			return s.encodeTo(io.MultiWriter(w, h), revision)
+		})
+		hash = hex.EncodeToString(h.Sum(nil))
+	} else {
+		var bytes []byte
+		if bytes, err = s.encode(revision); err != nil {
+			return 0, err
+		}
+		err = atomicWriteFile(fs, s.filePath(), bytes, s.writeOptions())
+		hash = hashBytes(bytes)
+	}
+	if err != nil {
+		if s.encryptionKey != "" {
+			return 0, fmt.Errorf("failed to write encrypted data: %w", err)
+		}
+		return 0, fmt.Errorf("failed to write JSON data: %w", err)
+	}
+	s.finishSave(hash, revision)
+	return revision, nil
+}
+
+// prepareSave makes sure the directory exists and, if resolve is set, merges
+// any conflicting changes, returning the revision to save as.
+func (s *Store[T]) prepareSave(fs FileSystem, resolve bool) (uint64, error) {
+	dir := filepath.Dir(s.filePath())
+
+	if err := s.checkNoSymlinks(fs); err != nil {
+		return 0, err
+	}
+
+	// Ensure directory
+	if err := fs.MkdirAll(dir, s.dirMode); err != nil {
+		return 0, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	if err := s.checkNoSymlinks(fs); err != nil {
+		return 0, err
+	}
+
+	if resolve && s.resolver != nil {
+		if err := s.resolveConflict(fs); err != nil {
+			return 0, err
+		}
+	}
+
+	revision := s.version.Revision
+	if s.trackRevisions {
+		revision++
+	}
+	return revision, nil
+}
+
+// finishSave records that the file hashing to hash (at revision) is now on disk.
+func (s *Store[T]) finishSave(hash string, revision uint64) {
+	s.dirty = false
+	s.version = Version{Revision: revision, Hash: hash}
+	if s.resolver != nil {
+		if dataJSON, err := json.Marshal(s.data); err == nil {
+			s.rememberBase(dataJSON)
+		}
+	}
+}
+
+// encode produces the bytes that go on disk: JSON, optionally wrapped in an
+// envelope (schema version, revision), optionally compressed, optionally encrypted.
+func (s *Store[T]) encode(revision uint64) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := s.encodeTo(&buf, revision); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeTo writes what encode returns to w. The JSON is streamed through
+// compression and CipherStream encryption; the other cipher formats seal the
+// whole file at once, so for them it is built in memory first.
+func (s *Store[T]) encodeTo(w io.Writer, revision uint64) error {
+	if s.encryptionKey == "" {
+		return s.writeCompressed(w, revision)
+	}
+
+	if s.cipherFormat == CipherStream {
+		ew, err := NewEncryptWriter(w, s.encryptionKey)
 		if err != nil {
 			return fmt.Errorf("failed to encrypt data: %w", err)
 		}
-		// 3) Perform atomic write with the encrypted data
-		if err := atomicWriteFile(s.fs, path, []byte(encrypted), s.enableBackup); err != nil {
-			return fmt.Errorf("failed to write encrypted data: %w", err)
+		if err := s.writeCompressed(ew, revision); err != nil {
+			return err
+		}
+		if err := ew.Close(); err != nil {
+			return fmt.Errorf("failed to encrypt data: %w", err)
 		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := s.writeCompressed(&buf, revision); err != nil {
+		return err
+	}
+	var encrypted []byte
+	if s.cipherFormat == CipherBinary {
+		b, err := EncryptBinary(s.encryptionKey, buf.Bytes())
+		if err != nil {
+			return fmt.Errorf("failed to encrypt data: %w", err)
+		}
+		encrypted = b
 	} else {
-		// 2) Plain JSON => atomic write
-		if err := atomicWriteFile(s.fs, path, bytes, s.enableBackup); err != nil {
-			return fmt.Errorf("failed to write JSON data: %w", err)
+		text, err := EncryptData(s.encryptionKey, buf.Bytes())
+		if err != nil {
+			return fmt.Errorf("failed to encrypt data: %w", err)
 		}
+		encrypted = []byte(text)
+	}
+	if _, err := w.Write(encrypted); err != nil {
+		return fmt.Errorf("failed to write encrypted data: %w", err)
+	}
+	return nil
+}
+
+// writeCompressed writes the JSON to w, through the compressor if one is set.
+// Compression comes before encryption; ciphertext doesn't compress.
+func (s *Store[T]) writeCompressed(w io.Writer, revision uint64) error {
+	if s.compression == nil {
+		return s.writeJSON(w, revision)
+	}
+	cw, err := s.compression.NewWriter(w)
+	if err != nil {
+		return fmt.Errorf("failed to create %s writer: %w", s.compression.Name(), err)
+	}
+	if err := s.writeJSON(cw, revision); err != nil {
+		return err
 	}
+	if err := cw.Close(); err != nil {
+		return fmt.Errorf("failed to finish %s stream: %w", s.compression.Name(), err)
+	}
+	return nil
+}
 
+// writeJSON writes the data as indented JSON, in an envelope if the store
+// uses one. Only streaming saves encode it element by element; the rest
+// marshal it in one go, as encoding/json would.
+func (s *Store[T]) writeJSON(w io.Writer, revision uint64) error {
+	var v any = s.data
+	if s.useEnvelope() {
+		v = envelope{Meta: envelopeMeta{Schema: s.schemaVersion, Revision: revision}, Data: s.data}
+	}
+	var err error
+	switch {
+	case !s.streamWrites && !s.streaming():
+		err = writeMarshaled(w, v, "")
+	case s.useEnvelope():
+		err = writeEnvelope(w, v.(envelope))
+	default:
+		err = writeIndentedJSON(w, v, "")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal data as JSON: %w", err)
+	}
 	return nil
 }
 
-// Get returns the in-memory data.
+// Get returns the in-memory data (a copy if Isolate is set). If the copy
+// fails, which only T's own JSON methods can make happen, Get returns the
+// zero T rather than share the data.
 func (s *Store[T]) Get() T {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.data
+	v, _ := s.isolated(s.data)
+	return v
 }
 
-// Set replaces the entire in-memory data.
+// Set replaces the entire in-memory data (with a copy if Isolate is set).
+// If val can't be copied, the data is left as it was; that error, or with
+// an audit sink a failure to record the change, is returned by the next
+// Save. Use SetContext to get them right away. After Close, Set does
+// nothing.
 func (s *Store[T]) Set(val T) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	_ = s.set(context.Background(), val, true)
+}
+
+// SetContext is Set with a context, which names the actor for the audit
+// record (see WithActor). It returns ErrClosed after Close, an error if the
+// store's lock couldn't be taken before ctx was done or val couldn't be
+// copied, or the audit record's error; in the last case the data has still
+// been replaced.
+func (s *Store[T]) SetContext(ctx context.Context, val T) error {
+	return s.set(ctx, val, false)
+}
+
+func (s *Store[T]) set(ctx context.Context, val T, deferErr bool) error {
+	val, copyErr := s.isolated(val)
+
+	if err := s.lockContext(ctx); err != nil {
+		return err
+	}
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	prev, err := s.undoSnapshot()
+	if err = errors.Join(copyErr, err); err != nil {
+		if deferErr {
+			s.recordErr = errors.Join(s.recordErr, err)
+			err = nil
+		}
+		s.mu.Unlock()
+		return err
+	}
+	s.pushUndo(prev)
 	s.data = val
+	s.dirty = true
 	if s.cache != nil {
 		s.cache.Set("all", val)
 	}
+	err = s.auditSet(ctx)
+	if err != nil && deferErr {
+		s.recordErr = err
+		err = nil
+	}
+	s.mu.Unlock()
+
+	if s.autosave != nil {
+		s.autosave.schedule()
+	}
+	return err
 }
 
-// filePath -> /basePath/subDir/fileName
-func (s *Store[T]) filePath() string {
-	if s.subDir == "" {
-		return filepath.Join(s.basePath, s.fileName)
+// modify lets fn change the data in place under the store's lock, with
+// what Set does around it: an undo snapshot beforehand and, if fn reports
+// a change, the dirty flag, cache, audit record and autosave. It returns
+// what SetContext would.
+func (s *Store[T]) modify(ctx context.Context, fn func(data *T) bool) error {
+	if err := s.lockContext(ctx); err != nil {
+		return err
 	}
-	return filepath.Join(s.basePath, s.subDir, s.fileName)
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	prev, err := s.undoSnapshot()
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if !fn(&s.data) {
+		s.mu.Unlock()
+		return nil
+	}
+	s.pushUndo(prev)
+	s.dirty = true
+	if s.cache != nil {
+		s.cache.Set("all", s.data)
+	}
+	err = s.auditSet(ctx)
+	s.mu.Unlock()
+
+	if s.autosave != nil {
+		s.autosave.schedule()
+	}
+	return err
 }
 
-// atomicWriteFile writes data to .tmp, optionally backups .bak, then renames to final.
-func atomicWriteFile(fs FileSystem, finalPath string, data []byte, enableBackup bool) error {
-	tmpPath := finalPath + ".tmp"
-	bakPath := finalPath + ".bak"
+// Update replaces the data with the result of fn and saves it, all under the
+// store's lock so no other Set or Save can interleave. If fn returns an error,
+// nothing is changed.
+func (s *Store[T]) Update(fn func(T) (T, error)) error {
+	return s.UpdateContext(context.Background(), fn)
+}
 
-	// Backup old file
-	if enableBackup {
-		if _, err := fs.Stat(finalPath); err == nil {
-			if err := fs.Rename(finalPath, bakPath); err != nil {
-				return fmt.Errorf("failed to rename old file to .bak: %w", err)
-			}
-		}
+// UpdateContext is Update with a context, see SaveContext.
+func (s *Store[T]) UpdateContext(ctx context.Context, fn func(T) (T, error)) error {
+	if err := s.lockContext(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+
+	prev, err := s.undoSnapshot()
+	if err != nil {
+		return err
+	}
+	cur, err := s.isolated(s.data)
+	if err != nil {
+		return err
+	}
+	val, err := fn(cur)
+	if err != nil {
+		return err
+	}
+	if val, err = s.isolated(val); err != nil {
+		return err
+	}
+	s.pushUndo(prev)
+	s.data = val
+	s.dirty = true
+	if s.cache != nil {
+		s.cache.Set("all", s.data)
 	}
+	if err := s.auditSet(ctx); err != nil {
+		s.recordErr = err // returned by the save below
+	}
+	return s.save(ctx, fsWithContext(ctx, s.fs))
+}
+
+// IsDirty reports whether the in-memory data has changed since it was last loaded or saved.
+func (s *Store[T]) IsDirty() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dirty
+}
 
-	// Write to .tmp
-	if err := fs.WriteFile(tmpPath, data, 0600); err != nil {
-		return fmt.Errorf("failed to write .tmp file: %w", err)
+// Close flushes any unsaved changes and stops background work (autosave,
+// cache cleanup). Afterwards Load, Save, SetContext, Update and the like
+// return ErrClosed and Set does nothing; Get still returns the last value.
+// If the flush fails, the store stays open (without autosave) so the data
+// can still be saved, and Close can be retried.
+func (s *Store[T]) Close() error {
+	if s.autosave != nil {
+		s.autosave.stop()
 	}
 
-	// Rename tmp -> final
-	if err := fs.Rename(tmpPath, finalPath); err != nil {
-		return fmt.Errorf("failed to rename %s -> %s: %w", tmpPath, finalPath, err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.dirty {
+		if err := s.save(context.Background(), s.fs); err != nil {
+			return fmt.Errorf("failed to flush on close: %w", err)
+		}
 	}
 
+	s.closed = true
+	if s.cache != nil {
+		s.cache.Close()
+	}
 	return nil
 }
+
+// flush saves the data if it is dirty.
+func (s *Store[T]) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if !s.dirty {
+		return nil
+	}
+	return s.save(context.Background(), s.fs)
+}
+
+// writeOptions collects the settings used for atomic writes.
+func (s *Store[T]) writeOptions() writeOptions {
+	return writeOptions{
+		backup:  s.enableBackup,
+		durable: s.durable,
+		perm:    s.fileMode,
+		owner:   s.owner,
+	}
+}
+
+// filePath -> /basePath/subDir/fileName
+func (s *Store[T]) filePath() string {
+	if s.subDir == "" {
+		return filepath.Join(s.basePath, s.fileName)
+	}
+	return filepath.Join(s.basePath, s.subDir, s.fileName)
+}
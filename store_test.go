@@ -1,8 +1,12 @@
 package jankdb_test
 
 import (
+	"context"
 	"errors"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -135,3 +139,353 @@ func (m mockFileInfo) Mode() os.FileMode      { return 0644 }
 func (m mockFileInfo) ModTime() (t time.Time) { return }
 func (m mockFileInfo) IsDir() bool            { return false }
 func (m mockFileInfo) Sys() interface{}       { return nil }
+
+// newMemFS returns a MockFileSystem backed by an in-memory map of path -> contents.
+// The map is only safe to inspect directly once the store is idle.
+func newMemFS() (*testutil.MockFileSystem, map[string][]byte) {
+	var mu sync.Mutex
+	files := make(map[string][]byte)
+	mockFS := &testutil.MockFileSystem{
+		StatFunc: func(path string) (os.FileInfo, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if _, ok := files[path]; ok {
+				return mockFileInfo{name: path}, nil
+			}
+			return nil, os.ErrNotExist
+		},
+		ReadFileFunc: func(path string) ([]byte, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			data, ok := files[path]
+			if !ok {
+				return nil, os.ErrNotExist
+			}
+			return append([]byte(nil), data...), nil
+		},
+		WriteFileFunc: func(path string, data []byte, perm os.FileMode) error {
+			mu.Lock()
+			defer mu.Unlock()
+			files[path] = append([]byte(nil), data...)
+			return nil
+		},
+		RenameFunc: func(src, dst string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			data, ok := files[src]
+			if !ok {
+				return os.ErrNotExist
+			}
+			files[dst] = data
+			delete(files, src)
+			return nil
+		},
+		RemoveFunc: func(path string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			delete(files, path)
+			return nil
+		},
+	}
+	return mockFS, files
+}
+
+func TestStore_LoadOrInit(t *testing.T) {
+	mockFS, files := newMemFS()
+
+	s, _ := jankdb.NewStore[map[string]int](mockFS, "/base", jankdb.StoreOptions{
+		FileName:    "counts.json",
+		SaveDefault: true,
+	})
+	s.SetDefault(func() map[string]int { return map[string]int{"start": 1} })
+
+	created, err := s.LoadOrInit()
+	if err != nil {
+		t.Fatalf("LoadOrInit failed: %v", err)
+	}
+	if !created {
+		t.Error("expected store to be freshly created")
+	}
+	if s.Get()["start"] != 1 {
+		t.Errorf("expected default value, got %v", s.Get())
+	}
+	if _, ok := files["/base/counts.json"]; !ok {
+		t.Error("expected default to be written to disk")
+	}
+
+	created, err = s.LoadOrInit()
+	if err != nil {
+		t.Fatalf("second LoadOrInit failed: %v", err)
+	}
+	if created {
+		t.Error("expected existing file to be loaded, not created")
+	}
+
+	// Without a default there is nothing to initialize
+	noDefault, _ := jankdb.NewStore[map[string]int](mockFS, "/base", jankdb.StoreOptions{
+		FileName:    "other.json",
+		SaveDefault: true,
+	})
+	created, err = noDefault.LoadOrInit()
+	if err != nil || created {
+		t.Errorf("expected created=false without a default, got %v, %v", created, err)
+	}
+	if _, ok := files["/base/other.json"]; ok {
+		t.Error("expected nothing to be written without a default")
+	}
+}
+
+func TestStore_Close(t *testing.T) {
+	mockFS, files := newMemFS()
+	s, _ := jankdb.NewStore[string](mockFS, "/base", jankdb.StoreOptions{
+		FileName:          "c.json",
+		UseCache:          true,
+		DefaultExpiration: time.Minute,
+		CleanupInterval:   time.Millisecond,
+	})
+
+	s.Set("pending")
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if string(files["/base/c.json"]) != `"pending"` {
+		t.Errorf("expected dirty data to be flushed, got %q", files["/base/c.json"])
+	}
+
+	if err := s.Save(); !errors.Is(err, jankdb.ErrClosed) {
+		t.Errorf("expected ErrClosed from Save, got %v", err)
+	}
+	if err := s.Load(); !errors.Is(err, jankdb.ErrClosed) {
+		t.Errorf("expected ErrClosed from Load, got %v", err)
+	}
+	if err := s.Close(); !errors.Is(err, jankdb.ErrClosed) {
+		t.Errorf("expected ErrClosed from second Close, got %v", err)
+	}
+
+	// Changes after Close are refused rather than silently dropped
+	if err := s.SetContext(context.Background(), "late"); !errors.Is(err, jankdb.ErrClosed) {
+		t.Errorf("expected ErrClosed from SetContext, got %v", err)
+	}
+	s.Set("late")
+	if s.Get() != "pending" || s.IsDirty() {
+		t.Errorf("expected Set after Close to do nothing, got %q dirty=%v", s.Get(), s.IsDirty())
+	}
+	if err := s.Update(func(string) (string, error) { return "late", nil }); !errors.Is(err, jankdb.ErrClosed) {
+		t.Errorf("expected ErrClosed from Update, got %v", err)
+	}
+}
+
+func TestStore_CloseFlushFailureIsRetryable(t *testing.T) {
+	mockFS, files := newMemFS()
+	write := mockFS.WriteFileFunc
+	failing := true
+	mockFS.WriteFileFunc = func(path string, data []byte, perm os.FileMode) error {
+		if failing {
+			return errors.New("disk full")
+		}
+		return write(path, data, perm)
+	}
+	s, _ := jankdb.NewStore[string](mockFS, "/base", jankdb.StoreOptions{FileName: "c.json"})
+
+	s.Set("pending")
+	if err := s.Close(); err == nil {
+		t.Fatal("expected the failed flush to be reported")
+	}
+	failing = false
+	if err := s.Close(); err != nil {
+		t.Fatalf("retried Close failed: %v", err)
+	}
+	if string(files["/base/c.json"]) != `"pending"` {
+		t.Errorf("expected the retry to flush, got %q", files["/base/c.json"])
+	}
+}
+
+func TestStore_Save_Durable(t *testing.T) {
+	mockFS, _ := newMemFS()
+	var calls []string
+	write, rename := mockFS.WriteFileFunc, mockFS.RenameFunc
+	// temp names are random, so record them as name.tmp-*
+	tmpName := func(path string) string {
+		if i := strings.Index(path, ".tmp-"); i >= 0 {
+			return path[:i] + ".tmp-*"
+		}
+		return path
+	}
+	mockFS.WriteFileFunc = func(path string, data []byte, perm os.FileMode) error {
+		calls = append(calls, "write "+tmpName(path))
+		return write(path, data, perm)
+	}
+	mockFS.RenameFunc = func(src, dst string) error {
+		calls = append(calls, "rename "+dst)
+		return rename(src, dst)
+	}
+	mockFS.SyncFileFunc = func(path string) error {
+		calls = append(calls, "syncfile "+tmpName(path))
+		return nil
+	}
+	mockFS.SyncDirFunc = func(dir string) error {
+		calls = append(calls, "syncdir "+dir)
+		return nil
+	}
+
+	s, _ := jankdb.NewStore[int](mockFS, "/base", jankdb.StoreOptions{
+		FileName: "n.json",
+		Durable:  true,
+	})
+	s.Set(1)
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	want := []string{
+		"write /base/n.json.tmp-*",
+		"syncfile /base/n.json.tmp-*",
+		"rename /base/n.json",
+		"syncdir /base",
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("expected calls %v, got %v", want, calls)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("call %d: expected %q, got %q", i, want[i], calls[i])
+		}
+	}
+}
+
+// plainFS hides every optional interface of the FileSystem it wraps.
+type plainFS struct{ jankdb.FileSystem }
+
+func TestStore_Durable_NeedsSyncer(t *testing.T) {
+	_, err := jankdb.NewStore[int](plainFS{jankdb.OSFileSystem{}}, t.TempDir(), jankdb.StoreOptions{
+		FileName: "n.json",
+		Durable:  true,
+	})
+	if err == nil {
+		t.Error("expected Durable on a FileSystem without Syncer to be refused")
+	}
+}
+
+func TestStore_Durable_ReadOnlyMode(t *testing.T) {
+	for _, streamed := range []bool{false, true} {
+		dir := t.TempDir()
+		s, _ := jankdb.NewStore[int](jankdb.OSFileSystem{}, dir, jankdb.StoreOptions{
+			FileName:     "n.json",
+			FileMode:     0o400,
+			Durable:      true,
+			StreamWrites: streamed,
+		})
+		for i := range 2 {
+			s.Set(i)
+			if err := s.Save(); err != nil {
+				t.Fatalf("durable Save (streamed=%v) of a 0400 file failed: %v", streamed, err)
+			}
+		}
+	}
+}
+
+func TestStore_ConcurrentSaves_OSFileSystem(t *testing.T) {
+	dir := t.TempDir()
+	fs := jankdb.OSFileSystem{}
+
+	// Several stores pointed at the same file, as if in separate processes
+	const writers, saves = 8, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*saves)
+	for w := 0; w < writers; w++ {
+		s, err := jankdb.NewStore[map[string]int](fs, dir, jankdb.StoreOptions{FileName: "shared.json"})
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < saves; i++ {
+				s.Set(map[string]int{"writer": w, "save": i})
+				if err := s.Save(); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent Save failed: %v", err)
+	}
+
+	reader, _ := jankdb.NewStore[map[string]int](fs, dir, jankdb.StoreOptions{FileName: "shared.json"})
+	if err := reader.Load(); err != nil {
+		t.Fatalf("expected a complete file after concurrent saves, got %v", err)
+	}
+	if reader.Get()["save"] != saves-1 {
+		t.Errorf("expected a final save, got %v", reader.Get())
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if e.Name() != "shared.json" {
+			t.Errorf("unexpected leftover file %s", e.Name())
+		}
+	}
+}
+
+func TestStore_Backup_FailedWriteKeepsFile(t *testing.T) {
+	mockFS, files := newMemFS()
+	s, _ := jankdb.NewStore[string](mockFS, "/base", jankdb.StoreOptions{
+		FileName:     "c.json",
+		EnableBackup: true,
+	})
+	s.Set("old")
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	write := mockFS.WriteFileFunc
+	mockFS.WriteFileFunc = func(path string, data []byte, perm os.FileMode) error {
+		return errors.New("disk full")
+	}
+	s.Set("new")
+	if err := s.Save(); err == nil {
+		t.Fatal("expected the failed write to be reported")
+	}
+	if string(files["/base/c.json"]) != `"old"` {
+		t.Errorf("expected the old file to stay in place, got %q", files["/base/c.json"])
+	}
+
+	mockFS.WriteFileFunc = write
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if string(files["/base/c.json"]) != `"new"` || string(files["/base/c.json.bak"]) != `"old"` {
+		t.Errorf("expected new file and old backup, got %q and %q", files["/base/c.json"], files["/base/c.json.bak"])
+	}
+}
+
+func TestStore_Load_RemovesStaleTemps(t *testing.T) {
+	dir := t.TempDir()
+	fs := jankdb.OSFileSystem{}
+
+	stale := filepath.Join(dir, "data.json.tmp-deadbeef")
+	fresh := filepath.Join(dir, "data.json.tmp-cafebabe")
+	for _, p := range []string{stale, fresh} {
+		if err := os.WriteFile(p, []byte("{"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	s, _ := jankdb.NewStore[map[string]int](fs, dir, jankdb.StoreOptions{FileName: "data.json"})
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("expected stale temp file to be removed")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Error("expected recent temp file to be left alone")
+	}
+}
@@ -0,0 +1,237 @@
+package jankdb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Streaming encryption follows the STREAM construction (Hoang, Reyhanitabar,
+// Rogaway, Vizár): the plaintext is cut into fixed-size segments, each sealed
+// with AES-GCM under a nonce made of a random prefix, the segment counter and
+// a "last segment" flag. Reordered segments fail the counter check, and a
+// stream cut at a segment boundary fails because its new final segment wasn't
+// sealed as last.
+//
+// Layout: magic | salt (16) | nonce prefix (7) | segment... where every
+// segment but the last holds exactly streamSegmentSize bytes of plaintext.
+
+// streamCipherMagic starts every NewEncryptWriter stream.
+var streamCipherMagic = []byte("\x00JDBS1")
+
+const (
+	streamSegmentSize = 64 * 1024
+	streamSaltSize    = 16
+	streamPrefixSize  = 7
+)
+
+// ErrStreamTruncated is returned when an encrypted stream ends early.
+var ErrStreamTruncated = errors.New("encrypted stream is truncated")
+
+// NewEncryptWriter returns a writer that encrypts everything written to it
+// into w using a passphrase-derived key. Close must be called to write the
+// final segment; it does not close w.
+func NewEncryptWriter(w io.Writer, passphrase string) (io.WriteCloser, error) {
+	salt := make([]byte, streamSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("failed to read random salt: %w", err)
+	}
+	prefix := make([]byte, streamPrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, fmt.Errorf("failed to read random nonce prefix: %w", err)
+	}
+	aead, err := streamAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append(append(append([]byte(nil), streamCipherMagic...), salt...), prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write stream header: %w", err)
+	}
+	return &streamWriter{
+		w:      w,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, 0, streamSegmentSize),
+	}, nil
+}
+
+// NewDecryptReader returns a reader that decrypts a stream written by
+// NewEncryptWriter. Reads fail if a segment was tampered with, reordered or
+// dropped, or if the stream was truncated.
+func NewDecryptReader(r io.Reader, passphrase string) (io.Reader, error) {
+	header := make([]byte, len(streamCipherMagic)+streamSaltSize+streamPrefixSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read stream header: %w", err)
+	}
+	if !bytes.HasPrefix(header, streamCipherMagic) {
+		return nil, errors.New("missing stream ciphertext header")
+	}
+	salt := header[len(streamCipherMagic) : len(streamCipherMagic)+streamSaltSize]
+	prefix := header[len(streamCipherMagic)+streamSaltSize:]
+
+	aead, err := streamAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	return &streamReader{
+		r:      bufio.NewReader(r),
+		aead:   aead,
+		prefix: append([]byte(nil), prefix...),
+		seg:    make([]byte, streamSegmentSize+aead.Overhead()),
+	}, nil
+}
+
+func streamAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 32768, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher block: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
+
+// streamNonce builds prefix || counter || last flag.
+func streamNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, streamPrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+type streamWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	err     error
+	closed  bool
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+	if sw.err != nil {
+		return 0, sw.err
+	}
+
+	n := len(p)
+	for len(p) > 0 {
+		// A full buffer is only sealed once more data arrives, so the
+		// final segment is never empty unless the whole stream is.
+		if len(sw.buf) == streamSegmentSize {
+			if sw.err = sw.seal(false); sw.err != nil {
+				return 0, sw.err
+			}
+		}
+		take := min(streamSegmentSize-len(sw.buf), len(p))
+		sw.buf = append(sw.buf, p[:take]...)
+		p = p[take:]
+	}
+	return n, nil
+}
+
+func (sw *streamWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	if sw.err != nil {
+		return sw.err
+	}
+	return sw.seal(true)
+}
+
+func (sw *streamWriter) seal(last bool) error {
+	if sw.counter == ^uint32(0) && !last {
+		return errors.New("encrypted stream too long")
+	}
+	sealed := sw.aead.Seal(nil, streamNonce(sw.prefix, sw.counter, last), sw.buf, nil)
+	if _, err := sw.w.Write(sealed); err != nil {
+		return fmt.Errorf("failed to write encrypted segment: %w", err)
+	}
+	sw.counter++
+	sw.buf = sw.buf[:0]
+	return nil
+}
+
+type streamReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	seg     []byte // ciphertext buffer
+	plain   []byte // decrypted, not yet returned
+	done    bool
+	err     error
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	for len(sr.plain) == 0 {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		if sr.done {
+			return 0, io.EOF
+		}
+		sr.err = sr.next()
+	}
+	n := copy(p, sr.plain)
+	sr.plain = sr.plain[n:]
+	return n, nil
+}
+
+// next decrypts the following segment into sr.plain.
+func (sr *streamReader) next() error {
+	n, err := io.ReadFull(sr.r, sr.seg)
+	last := false
+	switch {
+	case err == io.EOF:
+		// No bytes at all where a segment should be
+		return ErrStreamTruncated
+	case err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return fmt.Errorf("failed to read encrypted segment: %w", err)
+	default:
+		// A full segment is the last one only if nothing follows it
+		if _, peekErr := sr.r.Peek(1); peekErr == io.EOF {
+			last = true
+		} else if peekErr != nil {
+			return fmt.Errorf("failed to read encrypted segment: %w", peekErr)
+		}
+	}
+
+	plain, err := sr.aead.Open(nil, streamNonce(sr.prefix, sr.counter, last), sr.seg[:n], nil)
+	if err != nil {
+		if last {
+			return fmt.Errorf("%w or corrupted: segment %d failed to decrypt", ErrStreamTruncated, sr.counter)
+		}
+		return fmt.Errorf("failed to decrypt segment %d: %w", sr.counter, err)
+	}
+	sr.counter++
+	sr.plain = plain
+	sr.done = last
+	return nil
+}
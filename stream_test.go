@@ -0,0 +1,151 @@
+package jankdb_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/guarzo/jankdb"
+)
+
+// segment is the plaintext segment size, plus the GCM tag on disk.
+const (
+	segment       = 64 * 1024
+	sealedSegment = segment + 16
+	streamHeader  = 6 + 16 + 7
+)
+
+func encryptStream(t *testing.T, pass string, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := jankdb.NewEncryptWriter(&buf, pass)
+	if err != nil {
+		t.Fatalf("NewEncryptWriter failed: %v", err)
+	}
+	// Odd write sizes to cross segment boundaries mid-write
+	for len(plain) > 0 {
+		n := min(1000, len(plain))
+		if _, err := w.Write(plain[:n]); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		plain = plain[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decryptStream(pass string, ciphertext []byte) ([]byte, error) {
+	r, err := jankdb.NewDecryptReader(bytes.NewReader(ciphertext), pass)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestEncryptDecryptStream(t *testing.T) {
+	for _, size := range []int{0, 10, segment, segment + 1, 3*segment + 17} {
+		plain := bytes.Repeat([]byte("x"), size)
+		ciphertext := encryptStream(t, "pass", plain)
+
+		got, err := decryptStream("pass", ciphertext)
+		if err != nil {
+			t.Fatalf("size %d: decrypt failed: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: roundtrip mismatch (%d bytes back)", size, len(got))
+		}
+	}
+}
+
+func TestDecryptStream_Tampering(t *testing.T) {
+	plain := bytes.Repeat([]byte("abc"), segment) // three segments
+	ciphertext := encryptStream(t, "pass", plain)
+
+	if _, err := decryptStream("wrong", ciphertext); err == nil {
+		t.Error("expected error for wrong passphrase")
+	}
+
+	// Cut at a segment boundary: every remaining segment is intact, but
+	// the new final one wasn't sealed as last
+	cut := ciphertext[:streamHeader+sealedSegment]
+	if _, err := decryptStream("pass", cut); !errors.Is(err, jankdb.ErrStreamTruncated) {
+		t.Errorf("expected ErrStreamTruncated for cut stream, got %v", err)
+	}
+	if _, err := decryptStream("pass", ciphertext[:streamHeader]); !errors.Is(err, jankdb.ErrStreamTruncated) {
+		t.Errorf("expected ErrStreamTruncated for header only, got %v", err)
+	}
+
+	// Swap the first two segments
+	swapped := append([]byte(nil), ciphertext...)
+	first := ciphertext[streamHeader : streamHeader+sealedSegment]
+	second := ciphertext[streamHeader+sealedSegment : streamHeader+2*sealedSegment]
+	copy(swapped[streamHeader:], second)
+	copy(swapped[streamHeader+sealedSegment:], first)
+	if _, err := decryptStream("pass", swapped); err == nil {
+		t.Error("expected error for reordered segments")
+	}
+
+	flipped := append([]byte(nil), ciphertext...)
+	flipped[len(flipped)-1] ^= 1
+	if _, err := decryptStream("pass", flipped); err == nil {
+		t.Error("expected error for modified ciphertext")
+	}
+}
+
+func TestStore_CipherStream(t *testing.T) {
+	dir := t.TempDir()
+	opts := jankdb.StoreOptions{
+		FileName:      "big.json",
+		EncryptionKey: "pass",
+		CipherFormat:  jankdb.CipherStream,
+		Compression:   jankdb.GzipCompression,
+	}
+	s, err := jankdb.NewStore[map[string]int](jankdb.OSFileSystem{}, dir, opts)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	s.Set(benchItems(5000))
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	path := filepath.Join(dir, "big.json")
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if !bytes.HasPrefix(raw, []byte("\x00JDBS1")) {
+		t.Errorf("expected stream ciphertext header, got %q", raw[:6])
+	}
+
+	loaded, _ := jankdb.NewStore[map[string]int](jankdb.OSFileSystem{}, dir, opts)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := loaded.Get(); len(got) != 5000 || got["item-42"] != 42 {
+		t.Errorf("unexpected data after load: %d items", len(got))
+	}
+	if loaded.Version() != s.Version() {
+		t.Errorf("expected version %+v after load, got %+v", s.Version(), loaded.Version())
+	}
+
+	// Stores using another cipher format still read stream files
+	opts.CipherFormat = jankdb.CipherBinary
+	other, _ := jankdb.NewStore[map[string]int](jankdb.OSFileSystem{}, dir, opts)
+	if err := other.Load(); err != nil {
+		t.Fatalf("Load with CipherBinary failed: %v", err)
+	}
+
+	// A truncated file fails to load rather than yielding partial data
+	if err := os.WriteFile(path, raw[:len(raw)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Load(); err == nil {
+		t.Error("expected error loading truncated file")
+	}
+}
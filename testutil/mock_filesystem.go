@@ -20,10 +20,18 @@ type MockFileSystem struct {
 	ReadDirFunc    func(dir string) ([]os.DirEntry, error)
 	CreateFunc     func(path string) (*os.File, error)
 	RenameFunc     func(src, dst string) error
+	SyncFileFunc   func(path string) error
+	SyncDirFunc    func(dir string) error
+	ChmodFunc      func(path string, mode os.FileMode) error
+	ChownFunc      func(path string, uid, gid int) error
+	LstatFunc      func(path string) (os.FileInfo, error)
 }
 
 // Compile-time check that MockFileSystem implements jankdb.FileSystem
 var _ jankdb.FileSystem = (*MockFileSystem)(nil)
+var _ jankdb.Syncer = (*MockFileSystem)(nil)
+var _ jankdb.PermissionFileSystem = (*MockFileSystem)(nil)
+var _ jankdb.SymlinkFileSystem = (*MockFileSystem)(nil)
 
 func (m *MockFileSystem) ReadFile(path string) ([]byte, error) {
 	if m.ReadFileFunc == nil {
@@ -91,3 +99,33 @@ func (m *MockFileSystem) Rename(src, dst string) error {
 	}
 	return m.RenameFunc(src, dst)
 }
+func (m *MockFileSystem) SyncFile(path string) error {
+	if m.SyncFileFunc == nil {
+		return nil
+	}
+	return m.SyncFileFunc(path)
+}
+func (m *MockFileSystem) SyncDir(dir string) error {
+	if m.SyncDirFunc == nil {
+		return nil
+	}
+	return m.SyncDirFunc(dir)
+}
+func (m *MockFileSystem) Chmod(path string, mode os.FileMode) error {
+	if m.ChmodFunc == nil {
+		return nil
+	}
+	return m.ChmodFunc(path, mode)
+}
+func (m *MockFileSystem) Chown(path string, uid, gid int) error {
+	if m.ChownFunc == nil {
+		return nil
+	}
+	return m.ChownFunc(path, uid, gid)
+}
+func (m *MockFileSystem) Lstat(path string) (os.FileInfo, error) {
+	if m.LstatFunc == nil {
+		return nil, os.ErrNotExist
+	}
+	return m.LstatFunc(path)
+}
@@ -8,6 +8,9 @@ type MockStore[T any] struct {
 	SaveFunc func() error
 	GetFunc  func() T
 	SetFunc  func(T)
+
+	IsDirtyFunc func() bool
+	CloseFunc   func() error
 }
 
 func (m *MockStore[T]) Load() error {
@@ -34,3 +37,15 @@ func (m *MockStore[T]) Set(val T) {
 		m.SetFunc(val)
 	}
 }
+func (m *MockStore[T]) IsDirty() bool {
+	if m.IsDirtyFunc != nil {
+		return m.IsDirtyFunc()
+	}
+	return false
+}
+func (m *MockStore[T]) Close() error {
+	if m.CloseFunc != nil {
+		return m.CloseFunc()
+	}
+	return nil
+}
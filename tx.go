@@ -0,0 +1,402 @@
+package jankdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// txManifestPrefix names the intent files a commit writes into the base path.
+const txManifestPrefix = ".jankdb-txn-"
+
+// invalidManifestSuffix is appended to a manifest that can't be applied, so
+// it's kept for inspection but no longer blocks every Load.
+const invalidManifestSuffix = ".invalid"
+
+// ErrTxIncomplete is wrapped by the error Commit returns when it failed after
+// the commit point. The transaction has happened and must not be retried or
+// rolled back: the next Load, Save or Commit under the base path finishes
+// moving its files into place.
+var ErrTxIncomplete = errors.New("transaction committed, but not all files are in place yet")
+
+// Transactional is implemented by *Store[T], so stores of different types
+// can be committed together by a Tx.
+type Transactional interface {
+	txTarget() (fs FileSystem, basePath, path string)
+	txLock(ctx context.Context) error
+	txUnlock()
+	txPrepare(fs FileSystem) (txFile, error)
+	txCommitted(ctx context.Context, f txFile)
+}
+
+// Tx saves several stores under the same base path (and FileSystem) as one unit: after a
+// crash, the next Load of any of them sees either all of the new files or none.
+//
+//	inventory.Set(inv)
+//	archive.Set(arc)
+//	err := jankdb.NewTx(inventory, archive).Commit()
+type Tx struct {
+	stores []Transactional
+	fs     FileSystem // if set, the FileSystem every store is known to use
+}
+
+// NewTx creates a transaction over the given stores.
+func NewTx(stores ...Transactional) *Tx {
+	return &Tx{stores: stores}
+}
+
+// Add includes more stores in the transaction.
+func (tx *Tx) Add(stores ...Transactional) *Tx {
+	tx.stores = append(tx.stores, stores...)
+	return tx
+}
+
+// Commit saves the current in-memory data of every store in the transaction.
+// New files are staged next to the old ones, an intent manifest listing them
+// is written to the base path (the commit point), and then they are renamed
+// into place. If anything fails before the manifest is written, nothing changes.
+func (tx *Tx) Commit() error {
+	return tx.CommitContext(context.Background())
+}
+
+// CommitContext is Commit with a context, see SaveContext. ctx can only stop
+// the commit before its commit point; once the manifest is written, the
+// files are moved into place regardless.
+func (tx *Tx) CommitContext(ctx context.Context) error {
+	if len(tx.stores) == 0 {
+		return nil
+	}
+
+	// Lock in path order so two overlapping transactions can't deadlock
+	stores := make([]Transactional, 0, len(tx.stores))
+	seen := make(map[string]bool)
+	fs, basePath, _ := tx.stores[0].txTarget()
+	for _, s := range tx.stores {
+		sfs, sbase, path := s.txTarget()
+		if filepath.Clean(sbase) != filepath.Clean(basePath) {
+			return fmt.Errorf("transaction stores must share a base path: %s vs %s", sbase, basePath)
+		}
+		if tx.fs == nil {
+			same, err := sameFileSystem(sfs, fs)
+			if err != nil {
+				return err
+			}
+			if !same {
+				return fmt.Errorf("transaction stores must share a FileSystem: %s uses a different one", path)
+			}
+		}
+		if !seen[path] {
+			seen[path] = true
+			stores = append(stores, s)
+		}
+	}
+	sort.Slice(stores, func(i, j int) bool {
+		_, _, a := stores[i].txTarget()
+		_, _, b := stores[j].txTarget()
+		return a < b
+	})
+
+	for i, s := range stores {
+		if err := s.txLock(ctx); err != nil {
+			for _, locked := range stores[:i] {
+				locked.txUnlock()
+			}
+			return err
+		}
+	}
+	defer func() {
+		for _, s := range stores {
+			s.txUnlock()
+		}
+	}()
+
+	// A crashed commit must land before this one, see Store.write
+	if err := recoverTransactions(fsWithContext(ctx, fs), basePath); err != nil {
+		return err
+	}
+	files := make([]txFile, 0, len(stores))
+	for _, s := range stores {
+		f, err := s.txPrepare(fsWithContext(ctx, fs))
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+
+	err := commitFiles(ctx, fs, basePath, files)
+	if err != nil && !errors.Is(err, ErrTxIncomplete) {
+		return err
+	}
+	// Past the commit point the new data is what the files will hold
+	for i, s := range stores {
+		s.txCommitted(ctx, files[i])
+	}
+	return err
+}
+
+// sameFileSystem reports whether a and b are the same FileSystem value. It
+// returns an error if that can't be told: values holding maps, slices or
+// funcs can't be compared, so pass such a FileSystem by pointer.
+func sameFileSystem(a, b FileSystem) (bool, error) {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if !va.IsValid() || !vb.IsValid() {
+		return va.IsValid() == vb.IsValid(), nil
+	}
+	if va.Type() != vb.Type() {
+		return false, nil
+	}
+	if !va.Comparable() || !vb.Comparable() {
+		return false, fmt.Errorf("transaction stores must share a FileSystem, but %T values can't be compared; pass it by pointer", a)
+	}
+	return va.Equal(vb), nil
+}
+
+// validateManifest checks a manifest read from disk. It's just a file in the
+// base path; don't let one rename anything outside it.
+func validateManifest(manifest txManifest) error {
+	for _, entry := range manifest.Files {
+		if err := errors.Join(validateRelPath("staged", entry.Staged), validateRelPath("final", entry.Final)); err != nil {
+			return fmt.Errorf("invalid transaction manifest: %w", err)
+		}
+		if !strings.HasPrefix(entry.Staged, entry.Final+".tmp-txn-") {
+			return fmt.Errorf("invalid transaction manifest: %q isn't staged for %q", entry.Staged, entry.Final)
+		}
+	}
+	return nil
+}
+
+// txFile is one file written by commitFiles.
+type txFile struct {
+	path     string
+	data     []byte
+	opts     writeOptions
+	revision uint64
+}
+
+// txManifest is the intent file; paths are relative to the base path.
+type txManifest struct {
+	Files []txManifestEntry `json:"files"`
+}
+
+type txManifestEntry struct {
+	Staged string `json:"staged"`
+	Final  string `json:"final"`
+}
+
+// commitFiles atomically replaces several files under basePath. ctx is
+// only checked up to the commit point.
+func commitFiles(ctx context.Context, fs FileSystem, basePath string, files []txFile) error {
+	id, err := randomID()
+	if err != nil {
+		return err
+	}
+	stageFS := fsWithContext(ctx, fs)
+
+	// 1) Stage every file
+	var manifest txManifest
+	durable := false
+	var staged []string
+	cleanup := func() {
+		for _, p := range staged {
+			_ = fs.Remove(p)
+		}
+	}
+	for _, f := range files {
+		stagedPath := f.path + ".tmp-txn-" + id
+		if err := writeStaged(stageFS, stagedPath, f); err != nil {
+			cleanup()
+			return err
+		}
+		staged = append(staged, stagedPath)
+
+		relStaged, err1 := filepath.Rel(basePath, stagedPath)
+		relFinal, err2 := filepath.Rel(basePath, f.path)
+		if err := errors.Join(err1, err2); err != nil {
+			cleanup()
+			return fmt.Errorf("failed to make path relative to %s: %w", basePath, err)
+		}
+		manifest.Files = append(manifest.Files, txManifestEntry{Staged: relStaged, Final: relFinal})
+		durable = durable || f.opts.durable
+	}
+
+	// 2) Commit point: the manifest
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		cleanup()
+		return fmt.Errorf("failed to marshal transaction manifest: %w", err)
+	}
+	manifestPath := filepath.Join(basePath, txManifestPrefix+id+".json")
+	if err := atomicWriteFile(stageFS, manifestPath, manifestData, writeOptions{durable: durable}); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to write transaction manifest: %w", err)
+	}
+
+	// 3) Move everything into place. From here on, a failure is finished by
+	// the next Load, Save or Commit rather than rolled back.
+	for _, f := range files {
+		if f.opts.backup {
+			if _, err := fs.Stat(f.path); err == nil {
+				if err := fs.Rename(f.path, f.path+".bak"); err != nil && !fs.IsNotExist(err) {
+					return fmt.Errorf("%w: failed to rename old file to .bak: %w", ErrTxIncomplete, err)
+				}
+			}
+		}
+	}
+	if err := applyManifest(fs, basePath, manifestPath, manifest, durable); err != nil {
+		return fmt.Errorf("%w: %w", ErrTxIncomplete, err)
+	}
+	return nil
+}
+
+// writeStaged writes one staged file with the same permissions and syncing as atomicWriteFile.
+func writeStaged(fs FileSystem, stagedPath string, f txFile) error {
+	perm := f.opts.perm
+	if perm == 0 {
+		perm = 0600
+	}
+	if existing, err := fs.Stat(f.path); err == nil && existing != nil {
+		perm = existing.Mode().Perm()
+	}
+
+	if err := fs.WriteFile(stagedPath, f.data, perm); err != nil {
+		_ = fs.Remove(stagedPath)
+		return fmt.Errorf("failed to stage %s: %w", f.path, err)
+	}
+	if err := applyPermissions(fs, stagedPath, perm, f.opts.owner); err != nil {
+		_ = fs.Remove(stagedPath)
+		return err
+	}
+	if syncer, ok := fs.(Syncer); ok && f.opts.durable {
+		if err := syncer.SyncFile(stagedPath); err != nil {
+			_ = fs.Remove(stagedPath)
+			return fmt.Errorf("failed to sync staged file: %w", err)
+		}
+	}
+	return nil
+}
+
+// applyManifest renames staged files over their targets and removes the manifest.
+// It is safe to run more than once, and by more than one process.
+func applyManifest(fs FileSystem, basePath, manifestPath string, manifest txManifest, durable bool) error {
+	dirs := make(map[string]bool)
+	for _, entry := range manifest.Files {
+		staged := filepath.Join(basePath, entry.Staged)
+		final := filepath.Join(basePath, entry.Final)
+		if err := fs.Rename(staged, final); err != nil && !fs.IsNotExist(err) {
+			return fmt.Errorf("failed to commit %s: %w", final, err)
+		}
+		dirs[filepath.Dir(final)] = true
+	}
+
+	if syncer, ok := fs.(Syncer); ok && durable {
+		for dir := range dirs {
+			if err := syncer.SyncDir(dir); err != nil {
+				return fmt.Errorf("failed to sync directory %s: %w", dir, err)
+			}
+		}
+	}
+
+	if err := fs.Remove(manifestPath); err != nil && !fs.IsNotExist(err) {
+		return fmt.Errorf("failed to remove transaction manifest: %w", err)
+	}
+	return nil
+}
+
+// recoverTransactions finishes any commit that crashed after writing its
+// manifest. Load calls it before reading so it never sees half a
+// transaction, and Save and Commit before writing so an old transaction
+// can't land on top of newer data. A manifest that can't be parsed or names
+// paths it shouldn't is renamed to *.invalid and skipped.
+func recoverTransactions(fs FileSystem, basePath string) error {
+	entries, err := fs.ReadDir(basePath)
+	if err != nil {
+		// Nothing to recover if the base path doesn't exist (yet)
+		return nil
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, txManifestPrefix) || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		manifestPath := filepath.Join(basePath, name)
+		data, err := fs.ReadFile(manifestPath)
+		if fs.IsNotExist(err) {
+			// Another process finished it first
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to read transaction manifest %s: %w", name, err)
+		}
+
+		var manifest txManifest
+		if err := errors.Join(json.Unmarshal(data, &manifest), validateManifest(manifest)); err != nil {
+			// No commit of ours wrote this; set it aside rather than
+			// fail every Load and Save under the base path
+			if err := fs.Rename(manifestPath, manifestPath+invalidManifestSuffix); err != nil && !fs.IsNotExist(err) {
+				return fmt.Errorf("failed to set aside invalid transaction manifest %s: %w", name, err)
+			}
+			continue
+		}
+		if err := applyManifest(fs, basePath, manifestPath, manifest, false); err != nil {
+			return fmt.Errorf("failed to recover transaction %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Transactional implementation for Store[T].
+
+func (s *Store[T]) txTarget() (FileSystem, string, string) {
+	return s.fs, s.basePath, s.filePath()
+}
+
+func (s *Store[T]) txLock(ctx context.Context) error {
+	if err := s.lockContext(ctx); err != nil {
+		return err
+	}
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	return nil
+}
+
+func (s *Store[T]) txUnlock() {
+	s.mu.Unlock()
+}
+
+func (s *Store[T]) txPrepare(fs FileSystem) (txFile, error) {
+	revision, err := s.prepareSave(fs, true)
+	if err != nil {
+		return txFile{}, err
+	}
+	bytes, err := s.encode(revision)
+	if err != nil {
+		return txFile{}, err
+	}
+	return txFile{path: s.filePath(), data: bytes, opts: s.writeOptions(), revision: revision}, nil
+}
+
+func (s *Store[T]) txCommitted(ctx context.Context, f txFile) {
+	s.finishSave(hashBytes(f.data), f.revision)
+	// The commit is done and can't be undone; the next Save reports
+	// records that failed
+	var errs []error
+	if s.history != nil {
+		if err := s.appendHistory(ctx, s.fs, f.revision); err != nil {
+			errs = append(errs, fmt.Errorf("failed to record history: %w", err))
+		}
+	}
+	if err := s.auditSave(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to write audit record: %w", err))
+	}
+	if len(errs) > 0 {
+		s.recordErr = errors.Join(append([]error{s.recordErr}, errs...)...)
+	}
+}
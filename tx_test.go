@@ -0,0 +1,276 @@
+package jankdb_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guarzo/jankdb"
+)
+
+// crashFS fails every rename onto a path containing failOn, as if the
+// process died part way through a commit.
+type crashFS struct {
+	jankdb.OSFileSystem
+	failOn string
+}
+
+func (c crashFS) Rename(src, dst string) error {
+	if c.failOn != "" && strings.Contains(dst, c.failOn) {
+		return errors.New("simulated crash")
+	}
+	return c.OSFileSystem.Rename(src, dst)
+}
+
+func TestTx_Commit(t *testing.T) {
+	dir := t.TempDir()
+	fs := jankdb.OSFileSystem{}
+	inv, _ := jankdb.NewStore[[]string](fs, dir, jankdb.StoreOptions{FileName: "inventory.json"})
+	arc, _ := jankdb.NewStore[[]string](fs, dir, jankdb.StoreOptions{SubDir: "old", FileName: "archive.json"})
+
+	inv.Set([]string{"shield"})
+	arc.Set([]string{"sword"})
+	if err := jankdb.NewTx(inv, arc).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if inv.IsDirty() || arc.IsDirty() {
+		t.Error("expected stores to be clean after commit")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".jankdb-txn-") {
+			t.Errorf("manifest %s left behind", e.Name())
+		}
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "old", "archive.json"))
+	if !strings.Contains(string(data), "sword") {
+		t.Errorf("expected archive to be written, got %q", data)
+	}
+}
+
+func TestTx_CommitContext(t *testing.T) {
+	dir := t.TempDir()
+	fs := jankdb.OSFileSystem{}
+	inv, _ := jankdb.NewStore[[]string](fs, dir, jankdb.StoreOptions{FileName: "inventory.json"})
+	arc, _ := jankdb.NewStore[[]string](fs, dir, jankdb.StoreOptions{FileName: "archive.json"})
+	inv.Set([]string{"shield"})
+	arc.Set([]string{"sword"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := jankdb.NewTx(inv, arc).CommitContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected nothing written, found %d entries", len(entries))
+	}
+
+	// A held lock is waited for only until ctx is done
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		_ = arc.Update(func(v []string) ([]string, error) {
+			close(started)
+			<-release
+			return v, nil
+		})
+	}()
+	<-started
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := jankdb.NewTx(inv, arc).CommitContext(ctx)
+	close(release)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if err := jankdb.NewTx(inv, arc).CommitContext(context.Background()); err != nil {
+		t.Fatalf("CommitContext failed: %v", err)
+	}
+}
+
+func TestTx_RecoversAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	fs := jankdb.OSFileSystem{}
+	opts := func(name string) jankdb.StoreOptions { return jankdb.StoreOptions{FileName: name} }
+
+	// Initial state
+	inv, _ := jankdb.NewStore[[]string](fs, dir, opts("inventory.json"))
+	arc, _ := jankdb.NewStore[[]string](fs, dir, opts("archive.json"))
+	inv.Set([]string{"sword"})
+	arc.Set([]string{})
+	if err := jankdb.NewTx(inv, arc).Commit(); err != nil {
+		t.Fatalf("initial Commit failed: %v", err)
+	}
+
+	// Move the sword, but "crash" after inventory.json is renamed into place
+	crashing := crashFS{failOn: "inventory.json"}
+	inv2, _ := jankdb.NewStore[[]string](crashing, dir, opts("inventory.json"))
+	arc2, _ := jankdb.NewStore[[]string](crashing, dir, opts("archive.json"))
+	inv2.Set([]string{})
+	arc2.Set([]string{"sword"})
+	if err := jankdb.NewTx(inv2, arc2).Commit(); !errors.Is(err, jankdb.ErrTxIncomplete) {
+		t.Fatalf("expected ErrTxIncomplete from the simulated crash, got %v", err)
+	}
+
+	// archive.json was committed, inventory.json wasn't: Load must finish the job
+	reader, _ := jankdb.NewStore[[]string](fs, dir, opts("inventory.json"))
+	if err := reader.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := reader.Get(); len(got) != 0 {
+		t.Errorf("expected recovered inventory to be empty, got %v", got)
+	}
+	archive, _ := jankdb.NewStore[[]string](fs, dir, opts("archive.json"))
+	_ = archive.Load()
+	if got := archive.Get(); len(got) != 1 || got[0] != "sword" {
+		t.Errorf("expected archive [sword], got %v", got)
+	}
+}
+
+func TestTx_FailsBeforeCommitPoint(t *testing.T) {
+	dir := t.TempDir()
+	fs := jankdb.OSFileSystem{}
+	inv, _ := jankdb.NewStore[[]string](fs, dir, jankdb.StoreOptions{FileName: "inventory.json"})
+	bad, _ := jankdb.NewStore[chan int](fs, dir, jankdb.StoreOptions{FileName: "bad.json"})
+
+	inv.Set([]string{"sword"})
+	bad.Set(make(chan int)) // can't be marshaled
+	if err := jankdb.NewTx(inv, bad).Commit(); err == nil {
+		t.Fatal("expected Commit to fail")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("expected no files after failed commit, found %d", len(entries))
+	}
+}
+
+func TestTx_RecoveryRejectsEscapingManifest(t *testing.T) {
+	root := t.TempDir()
+	base := filepath.Join(root, "base")
+	_ = os.MkdirAll(base, 0o755)
+	_ = os.WriteFile(filepath.Join(base, "payload"), []byte("evil"), 0o600)
+	manifest := `{"files":[{"staged":"payload","final":"../victim.txt"}]}`
+	_ = os.WriteFile(filepath.Join(base, ".jankdb-txn-evil.json"), []byte(manifest), 0o600)
+
+	_ = os.WriteFile(filepath.Join(base, ".jankdb-txn-garbled.json"), []byte(`{"files":`), 0o600)
+
+	// Neither manifest may block the store; both are set aside
+	s, _ := jankdb.NewStore[string](jankdb.OSFileSystem{}, base, jankdb.StoreOptions{FileName: "a.json"})
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "victim.txt")); !os.IsNotExist(err) {
+		t.Error("manifest renamed a file outside the base path")
+	}
+	for _, name := range []string{".jankdb-txn-evil.json", ".jankdb-txn-garbled.json"} {
+		if _, err := os.Stat(filepath.Join(base, name+".invalid")); err != nil {
+			t.Errorf("expected %s to be set aside: %v", name, err)
+		}
+	}
+	s.Set("ok")
+	if err := s.Save(); err != nil {
+		t.Errorf("Save failed: %v", err)
+	}
+}
+
+func TestTx_CrashedCommitLandsBeforeNewerSave(t *testing.T) {
+	dir := t.TempDir()
+	fs := jankdb.OSFileSystem{}
+	inv, _ := jankdb.NewStore[[]string](fs, dir, jankdb.StoreOptions{FileName: "inventory.json"})
+	inv.Set([]string{"sword"})
+	if err := inv.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	crashed, _ := jankdb.NewStore[[]string](crashFS{failOn: "inventory.json"}, dir, jankdb.StoreOptions{FileName: "inventory.json"})
+	crashed.Set([]string{})
+	if err := jankdb.NewTx(crashed).Commit(); !errors.Is(err, jankdb.ErrTxIncomplete) {
+		t.Fatalf("expected ErrTxIncomplete, got %v", err)
+	}
+
+	// A plain Save after the crash must not be overwritten by it later
+	inv.Set([]string{"shield"})
+	if err := inv.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	reader, _ := jankdb.NewStore[[]string](fs, dir, jankdb.StoreOptions{FileName: "inventory.json"})
+	if err := reader.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := reader.Get(); len(got) != 1 || got[0] != "shield" {
+		t.Errorf("expected the newer save [shield], got %v", got)
+	}
+}
+
+// mapFS can't be compared with ==.
+type mapFS struct {
+	jankdb.OSFileSystem
+	tags map[string]string
+}
+
+func TestTx_UncomparableFileSystem(t *testing.T) {
+	dir := t.TempDir()
+	for _, fs := range []jankdb.FileSystem{
+		mapFS{},
+		struct{ jankdb.FileSystem }{mapFS{}}, // comparable type, uncomparable value
+	} {
+		a, _ := jankdb.NewStore[int](fs, dir, jankdb.StoreOptions{FileName: "a.json"})
+		b, _ := jankdb.NewStore[int](fs, dir, jankdb.StoreOptions{FileName: "b.json"})
+		a.Set(1)
+		b.Set(2)
+		if err := jankdb.NewTx(a, b).Commit(); err == nil || !strings.Contains(err.Error(), "pointer") {
+			t.Errorf("%T: expected an error asking for a pointer, got %v", fs, err)
+		}
+	}
+}
+
+func TestTx_RequiresSameFileSystem(t *testing.T) {
+	dir := t.TempDir()
+	a, _ := jankdb.NewStore[int](jankdb.OSFileSystem{}, dir, jankdb.StoreOptions{FileName: "a.json"})
+	b, _ := jankdb.NewStore[int](crashFS{}, dir, jankdb.StoreOptions{FileName: "b.json"})
+	a.Set(1)
+	b.Set(2)
+	if err := jankdb.NewTx(a, b).Commit(); err == nil {
+		t.Error("expected stores on different FileSystems to be refused")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.json")); !os.IsNotExist(err) {
+		t.Error("expected nothing to be written")
+	}
+}
+
+// flakyHistoryFS fails to open a history file once.
+type flakyHistoryFS struct {
+	jankdb.OSFileSystem
+	failed bool
+}
+
+func (f *flakyHistoryFS) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	if strings.HasSuffix(name, ".history") && !f.failed {
+		f.failed = true
+		return nil, errors.New("history unavailable")
+	}
+	return f.OSFileSystem.OpenFile(name, flag, perm)
+}
+
+func TestTx_HistoryFailureReportedByNextSave(t *testing.T) {
+	s, _ := jankdb.NewStore[int](&flakyHistoryFS{}, t.TempDir(), jankdb.StoreOptions{
+		FileName: "a.json",
+		History:  &jankdb.HistoryOptions{},
+	})
+	s.Set(1)
+	if err := jankdb.NewTx(s).Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := s.Save(); err == nil || !strings.Contains(err.Error(), "history unavailable") {
+		t.Errorf("expected the next Save to report the history failure, got %v", err)
+	}
+	if err := s.Save(); err != nil {
+		t.Errorf("expected the failure to be reported once, got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package jankdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNothingToUndo is returned by Undo when there is no earlier value.
+	ErrNothingToUndo = errors.New("nothing to undo")
+
+	// ErrNothingToRedo is returned by Redo when nothing has been undone
+	// since the last change.
+	ErrNothingToRedo = errors.New("nothing to redo")
+
+	// ErrNoCheckpoint is returned by DiscardToCheckpoint when the label
+	// isn't on the undo stack (or has fallen off its bottom).
+	ErrNoCheckpoint = errors.New("no such checkpoint")
+)
+
+// undoEntry is one value on the undo or redo stack.
+type undoEntry[T any] struct {
+	value T
+	label string // the checkpoint this value was, if any
+}
+
+// undoSnapshot copies the current data for the undo or redo stack; the
+// caller must hold s.mu. It's a deep copy even without Isolate, since
+// Update hands fn the live value.
+func (s *Store[T]) undoSnapshot() (undoEntry[T], error) {
+	if s.undoLimit <= 0 {
+		return undoEntry[T]{}, nil
+	}
+	v, err := clone(s.data)
+	if err != nil {
+		return undoEntry[T]{}, fmt.Errorf("failed to copy data for undo: %w", err)
+	}
+	return undoEntry[T]{value: v, label: s.undoLabel}, nil
+}
+
+// pushUndo records the value before a change (see undoSnapshot) and forgets
+// what was undone; the caller must hold s.mu.
+func (s *Store[T]) pushUndo(prev undoEntry[T]) {
+	if s.undoLimit <= 0 {
+		return
+	}
+	if len(s.undoStack) >= s.undoLimit {
+		clear(s.undoStack[:len(s.undoStack)-s.undoLimit+1])
+		s.undoStack = s.undoStack[len(s.undoStack)-s.undoLimit+1:]
+	}
+	s.undoStack = append(s.undoStack, prev)
+	clear(s.redoStack)
+	s.redoStack = s.redoStack[:0]
+	s.undoLabel = ""
+}
+
+// resetUndo forgets both stacks, e.g. when Load replaces the data.
+func (s *Store[T]) resetUndo() {
+	s.undoStack, s.redoStack, s.undoLabel = nil, nil, ""
+}
+
+// Undo puts back the value the data had before the last Set or Update (or
+// ApplyPatch, ...), up to StoreOptions.UndoLimit steps back. Like Set, it
+// only changes the data in memory, saved or not. Load clears the undo stack.
+//
+// Without Isolate, change the data only through Set and Update, not in place
+// on what Get returned, or the previous value is lost.
+func (s *Store[T]) Undo() error {
+	return s.step(&s.undoStack, &s.redoStack, ErrNothingToUndo)
+}
+
+// Redo reapplies the last change that Undo took back. Any new change
+// forgets what was undone.
+func (s *Store[T]) Redo() error {
+	return s.step(&s.redoStack, &s.undoStack, ErrNothingToRedo)
+}
+
+// step moves the data one entry back along from, pushing the current data onto to.
+func (s *Store[T]) step(from, to *[]undoEntry[T], empty error) error {
+	s.mu.Lock()
+	err := func() error {
+		if s.closed {
+			return ErrClosed
+		}
+		if len(*from) == 0 {
+			return empty
+		}
+		current, err := s.undoSnapshot()
+		if err != nil {
+			return err
+		}
+		*to = append(*to, current)
+		entry := (*from)[len(*from)-1]
+		(*from)[len(*from)-1] = undoEntry[T]{}
+		*from = (*from)[:len(*from)-1]
+		s.restore(entry)
+		return nil
+	}()
+	s.mu.Unlock()
+	return s.afterRestore(err)
+}
+
+// Checkpoint labels the current value, so DiscardToCheckpoint can return to
+// it. A label can be reused; the newest checkpoint with it counts.
+func (s *Store[T]) Checkpoint(label string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.undoLabel = label
+}
+
+// DiscardToCheckpoint throws away every change since the newest Checkpoint
+// with label, undoing them all at once. Unlike Undo, the discarded changes
+// can't be redone.
+func (s *Store[T]) DiscardToCheckpoint(label string) error {
+	s.mu.Lock()
+	if s.undoLabel == label && !s.closed {
+		// No changes since the checkpoint
+		s.mu.Unlock()
+		return nil
+	}
+	err := func() error {
+		if s.closed {
+			return ErrClosed
+		}
+		i := len(s.undoStack) - 1
+		for i >= 0 && s.undoStack[i].label != label {
+			i--
+		}
+		if i < 0 {
+			return fmt.Errorf("%w: %q", ErrNoCheckpoint, label)
+		}
+		entry := s.undoStack[i]
+		clear(s.undoStack[i:])
+		s.undoStack = s.undoStack[:i]
+		clear(s.redoStack)
+		s.redoStack = s.redoStack[:0]
+		s.restore(entry)
+		return nil
+	}()
+	s.mu.Unlock()
+	return s.afterRestore(err)
+}
+
+// restore makes entry the current data; the caller must hold s.mu.
+func (s *Store[T]) restore(entry undoEntry[T]) {
+	s.data = entry.value
+	s.undoLabel = entry.label
+	s.dirty = true
+	if s.cache != nil {
+		s.cache.Set("all", s.data)
+	}
+	if err := s.auditSet(context.Background()); err != nil {
+		s.recordErr = err // returned by the next Save
+	}
+}
+
+// afterRestore schedules an autosave, like Set, unless err says nothing changed.
+func (s *Store[T]) afterRestore(err error) error {
+	if err == nil && s.autosave != nil {
+		s.autosave.schedule()
+	}
+	return err
+}
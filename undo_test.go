@@ -0,0 +1,104 @@
+package jankdb_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/guarzo/jankdb"
+)
+
+func TestStore_UndoRedo(t *testing.T) {
+	mockFS, _ := newMemFS()
+	s, _ := jankdb.NewStore[map[string]int](mockFS, "/base", jankdb.StoreOptions{
+		FileName:  "doc.json",
+		UndoLimit: 3,
+	})
+	if err := s.Undo(); !errors.Is(err, jankdb.ErrNothingToUndo) {
+		t.Fatalf("expected ErrNothingToUndo, got %v", err)
+	}
+
+	s.Set(map[string]int{"a": 1})
+	// Update hands fn the live map; undo must still see the old value
+	_ = s.Update(func(m map[string]int) (map[string]int, error) {
+		m["a"] = 2
+		return m, nil
+	})
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	s.Set(map[string]int{"a": 3})
+
+	for _, want := range []int{2, 1} {
+		if err := s.Undo(); err != nil {
+			t.Fatalf("Undo failed: %v", err)
+		}
+		if got := s.Get()["a"]; got != want {
+			t.Fatalf("expected a=%d after undo, got %d", want, got)
+		}
+	}
+	if !s.IsDirty() {
+		t.Error("expected Undo to leave the store dirty")
+	}
+	if err := s.Redo(); err != nil || s.Get()["a"] != 2 {
+		t.Fatalf("Redo failed: %v %v", err, s.Get())
+	}
+
+	// A new change forgets what was undone
+	s.Set(map[string]int{"a": 4})
+	if err := s.Redo(); !errors.Is(err, jankdb.ErrNothingToRedo) {
+		t.Fatalf("expected ErrNothingToRedo, got %v", err)
+	}
+
+	// Only UndoLimit steps are kept: 5 <- 4 <- 2 <- 1, and nil fell off
+	s.Set(map[string]int{"a": 5})
+	undone := 0
+	for s.Undo() == nil {
+		undone++
+	}
+	if undone != 3 || s.Get()["a"] != 1 {
+		t.Errorf("expected 3 undos back to a=1, got %d to %v", undone, s.Get())
+	}
+}
+
+func TestStore_DiscardToCheckpoint(t *testing.T) {
+	mockFS, _ := newMemFS()
+	s, _ := jankdb.NewStore[[]string](mockFS, "/base", jankdb.StoreOptions{
+		FileName:  "list.json",
+		UndoLimit: 10,
+	})
+	s.Set([]string{"a"})
+	s.Checkpoint("before edit")
+	if err := s.DiscardToCheckpoint("before edit"); err != nil {
+		t.Fatalf("DiscardToCheckpoint with no changes failed: %v", err)
+	}
+	s.Set([]string{"a", "b"})
+	s.Set([]string{"a", "b", "c"})
+	s.Checkpoint("later")
+	s.Set(nil)
+
+	if err := s.DiscardToCheckpoint("before edit"); err != nil {
+		t.Fatalf("DiscardToCheckpoint failed: %v", err)
+	}
+	if got := s.Get(); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("expected [a], got %v", got)
+	}
+	// Discarded changes are gone for good, checkpoints after it too
+	if err := s.Redo(); !errors.Is(err, jankdb.ErrNothingToRedo) {
+		t.Errorf("expected ErrNothingToRedo, got %v", err)
+	}
+	if err := s.DiscardToCheckpoint("later"); !errors.Is(err, jankdb.ErrNoCheckpoint) {
+		t.Errorf("expected ErrNoCheckpoint, got %v", err)
+	}
+	// Undo still goes back past the checkpoint
+	if err := s.Undo(); err != nil || s.Get() != nil {
+		t.Errorf("expected Undo back to nil, got %v %v", err, s.Get())
+	}
+
+	// Load starts over
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := s.Undo(); !errors.Is(err, jankdb.ErrNothingToUndo) {
+		t.Errorf("expected Load to clear the undo stack, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package jankdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrConflict is returned by SaveIfVersion when the file on disk is no longer
+// the one the caller expected, i.e. someone else saved in the meantime.
+var ErrConflict = errors.New("store was modified since it was loaded")
+
+// Version identifies one state of a store's file on disk. Revision counts
+// saves (it is only persisted with TrackRevisions or a SchemaVersion); Hash
+// is the SHA-256 of the file's bytes. The zero Version means "no file".
+type Version struct {
+	Revision uint64
+	Hash     string
+}
+
+func hashBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Version returns the version of the file as of the last Load or Save.
+func (s *Store[T]) Version() Version {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version
+}
+
+// GetVersioned returns the in-memory data together with the version it was
+// loaded from (or last saved as), for a later SaveIfVersion. Like Get, it
+// returns the zero T if the data can't be copied.
+func (s *Store[T]) GetVersioned() (T, Version) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, _ := s.isolated(s.data)
+	return v, s.version
+}
+
+// SaveIfVersion saves only if the file on disk is still at `expected`,
+// otherwise it returns ErrConflict and writes nothing. This detects, rather
+// than prevents, concurrent writers: there is no lock between the check and the rename.
+func (s *Store[T]) SaveIfVersion(expected Version) error {
+	return s.SaveIfVersionContext(context.Background(), expected)
+}
+
+// SaveIfVersionContext is SaveIfVersion with a context, see SaveContext.
+func (s *Store[T]) SaveIfVersionContext(ctx context.Context, expected Version) error {
+	if err := s.lockContext(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+
+	fs := fsWithContext(ctx, s.fs)
+	disk, err := s.readFile(fs)
+	if err != nil {
+		return err
+	}
+	current := disk.version()
+	if current.Hash != expected.Hash {
+		return fmt.Errorf("%w: expected revision %d, found revision %d", ErrConflict, expected.Revision, current.Revision)
+	}
+
+	s.version.Revision = max(s.version.Revision, current.Revision)
+	return s.save(ctx, fs)
+}
+
+// diskFile is what readFile found on disk.
+type diskFile struct {
+	exists  bool
+	hash    string          // SHA-256 of the file's bytes
+	payload json.RawMessage // decrypted data, outside any envelope
+	meta    envelopeMeta
+}
+
+func (d diskFile) version() Version {
+	if !d.exists {
+		return Version{}
+	}
+	return Version{Revision: d.meta.Revision, Hash: d.hash}
+}
+
+// readFile reads and decodes the store's file without touching s.data.
+func (s *Store[T]) readFile(fs FileSystem) (diskFile, error) {
+	path := s.filePath()
+	if _, err := fs.Stat(path); fs.IsNotExist(err) {
+		return diskFile{}, nil
+	} else if err != nil {
+		return diskFile{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	_, plaintext, hash, err := s.readPlaintext(fs, path)
+	if err != nil {
+		return diskFile{}, err
+	}
+	payload, meta := unwrapEnvelope(plaintext)
+	if s.schemaVersion > 0 && meta.Schema != s.schemaVersion {
+		if payload, err = s.migrate(payload, meta.Schema); err != nil {
+			return diskFile{}, err
+		}
+	}
+	return diskFile{exists: true, hash: hash, payload: payload, meta: meta}, nil
+}
@@ -0,0 +1,70 @@
+package jankdb_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/guarzo/jankdb"
+)
+
+func TestStore_Revisions(t *testing.T) {
+	mockFS, _ := newMemFS()
+	opts := jankdb.StoreOptions{FileName: "r.json", TrackRevisions: true}
+
+	s, _ := jankdb.NewStore[int](mockFS, "/base", opts)
+	for i := 1; i <= 3; i++ {
+		s.Set(i)
+		if err := s.Save(); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	if rev := s.Version().Revision; rev != 3 {
+		t.Errorf("expected revision 3, got %d", rev)
+	}
+
+	s2, _ := jankdb.NewStore[int](mockFS, "/base", opts)
+	if err := s2.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	val, v := s2.GetVersioned()
+	if val != 3 || v.Revision != 3 || v.Hash != s.Version().Hash {
+		t.Errorf("expected value 3 at %+v, got %d at %+v", s.Version(), val, v)
+	}
+}
+
+func TestStore_SaveIfVersion_Conflict(t *testing.T) {
+	mockFS, files := newMemFS()
+	opts := jankdb.StoreOptions{FileName: "r.json", TrackRevisions: true}
+
+	a, _ := jankdb.NewStore[string](mockFS, "/base", opts)
+	b, _ := jankdb.NewStore[string](mockFS, "/base", opts)
+	_ = a.Load()
+	_ = b.Load()
+
+	_, va := a.GetVersioned()
+	a.Set("from a")
+	if err := a.SaveIfVersion(va); err != nil {
+		t.Fatalf("first SaveIfVersion failed: %v", err)
+	}
+
+	_, vb := b.GetVersioned()
+	b.Set("from b")
+	if err := b.SaveIfVersion(vb); !errors.Is(err, jankdb.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if !strings.Contains(string(files["/base/r.json"]), "from a") || b.Version() != vb {
+		t.Error("expected conflicting save to leave the file and version untouched")
+	}
+
+	// After reloading, b can save on top of a's revision
+	_ = b.Load()
+	_, vb = b.GetVersioned()
+	b.Set("from b")
+	if err := b.SaveIfVersion(vb); err != nil {
+		t.Fatalf("SaveIfVersion after reload failed: %v", err)
+	}
+	if rev := b.Version().Revision; rev != 2 {
+		t.Errorf("expected revision 2, got %d", rev)
+	}
+}